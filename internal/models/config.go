@@ -2,17 +2,66 @@ package models
 
 // UserConfig holds the user's configuration choices
 type UserConfig struct {
-	Mode             string   // "quick" or "custom"
-	SelectedTemplate string   // For quick mode (NixOS templates)
-	TemplateName     string   // For custom mode with preset template
-	Language         string   // For custom mode
-	LanguageVersion  string   // Selected version NixAttr (e.g., "python311")
-	Packages         []string // Language-specific packages (NixAttrs)
-	PyPIPackages     []string // PyPI packages to install with pip
-	Tools            []string // Dev tools (git, jq, etc.)
-	EnabledFeatures  []string // Selected feature NixAttrs
-	EnvVars          []string // Extra environment variable names (set to empty string)
-	UseFHS           bool     // Wrap devShell in buildFHSEnv (useful for CUDA)
-	NixpkgsURL       string   // Nixpkgs flake input URL (e.g., "github:NixOS/nixpkgs/nixos-unstable")
-	OutputPath       string   // Where to write flake.nix
+	Mode             string                     // "quick" or "custom"
+	SelectedTemplate string                     // For quick mode (NixOS templates)
+	TemplateName     string                     // For custom mode with preset template
+	Language         string                     // For custom mode
+	LanguageVersion  string                     // Selected version NixAttr (e.g., "python311")
+	Packages         []string                   // Language-specific packages (NixAttrs)
+	PackageOverrides map[string]PackageOverride // Per-package overrides, keyed by NixAttr
+	PyPIPackages     []string                   // PyPI packages to install with pip
+	Tools            []string                   // Dev tools (git, jq, etc.)
+	EnabledFeatures  []string                   // Selected feature NixAttrs
+	EnvVars          []string                   // Extra environment variable names (set to empty string)
+	ShellHook        []string                   // shellHook lines (snippets + custom commands), in order
+	Services         []string                   // Service names started via process-compose (see nix.Services)
+	UseFHS           bool                       // Wrap devShell in buildFHSEnv (useful for CUDA)
+	UseVenv          bool                       // Nix provides Python + native libs, uv/pip manage a .venv
+	UseUv2nix        bool                       // Generate from uv.lock via pyproject-nix/uv2nix instead of withPackages
+	UseCUDA          bool                       // Build nixpkgs with cudaSupport and swap packages to CUDA variants
+	UseROCm          bool                       // Build nixpkgs with rocmSupport and swap packages to ROCm variants
+	UseChecks        bool                       // Emit checks for the selected linters/test runners and a formatter output
+	UseImage         bool                       // Emit packages.dockerImage with the Python env and project source
+	UseGitHooks      bool                       // Install git-hooks.nix pre-commit hooks from the shellHook
+	AllowUnfree      bool                       // Set global allowUnfree instead of allowUnfreePredicate for the catalog's unfree packages
+	FeatureParams    map[string]string          // Chosen feature parameters (FeatureParam.Key → choice)
+	NixpkgsURL       string                     // Nixpkgs flake input URL (e.g., "github:NixOS/nixpkgs/nixos-unstable")
+	NixpkgsRev       string                     // Commit NixpkgsURL is pinned to (empty = follow the branch)
+	NixpkgsRevDate   string                     // Date of NixpkgsRev (YYYY-MM-DD), empty when unknown
+	NixpkgsRevBranch string                     // Branch NixpkgsRev was taken from, empty when unknown (e.g., typed by hand)
+	OutputPath       string                     // Where to write flake.nix
+	ExtraShells      []ShellProfile             // Additional devShells next to devShells.default
+	Inputs           []FlakeInput               // Extra flake inputs next to nixpkgs and flake-utils
+	PackageSources   map[string]string          // Input name a package or tool comes from, keyed by NixAttr (absent = nixpkgs)
+}
+
+// FlakeInput is an additional flake input added in the wizard
+type FlakeInput struct {
+	Name    string // input name, also used for the pkgs-<name> binding
+	URL     string // flake URL (e.g., "github:NixOS/nixpkgs/nixos-unstable")
+	Follows string // input whose nixpkgs this input's nixpkgs follows (empty = none)
+}
+
+// ShellProfile describes an additional named devShell. Env vars, the shellHook
+// and the flake-wide features (FHS, venv, checks, …) are shared with the
+// default shell.
+type ShellProfile struct {
+	Name     string   // devShells attribute name (e.g., "ci", "cuda")
+	Packages []string // Python package NixAttrs
+	Tools    []string // Dev tool NixAttrs
+	Features []string // Names of the per-shell features (CUDA, ROCm) enabled in this shell
+}
+
+// PackageOverride customises a nixpkgs Python package via overridePythonAttrs
+type PackageOverride struct {
+	Version       string   // Pinned PyPI version (empty = keep the nixpkgs version)
+	PyPIName      string   // PyPI project of the pinned version (empty = the package's pname)
+	DisableChecks bool     // Set doCheck = false
+	Patches       []string // Local patch files, relative to flake.nix
+	RelaxDeps     []string // Dependency names added to pythonRelaxDeps
+}
+
+// IsEmpty reports whether the override changes nothing.
+func (o PackageOverride) IsEmpty() bool {
+	return o.Version == "" && !o.DisableChecks && len(o.Patches) == 0 && len(o.RelaxDeps) == 0
 }
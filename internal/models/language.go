@@ -2,10 +2,21 @@ package models
 
 // NixpkgsChannel represents a versioned nixpkgs input
 type NixpkgsChannel struct {
-	Name                    string   // Display name (e.g., "nixos-unstable (recommended)")
-	FlakeURL                string   // Flake input URL
-	IsDefault               bool     // Whether this is the default choice
-	SupportedPythonVersions []string // Python NixAttrs available in this channel
+	Name                    string         // Display name (e.g., "nixos-unstable (recommended)")
+	FlakeURL                string         // Flake input URL
+	IsDefault               bool           // Whether this is the default choice
+	SupportedPythonVersions []string       // Python NixAttrs available in this channel
+	CUDAPackageSets         []string       // Versioned CUDA sets (e.g., "cudaPackages_12_4")
+	PackageIssues           []PackageIssue // Packages that fail evaluation on this channel unless allowed
+}
+
+// PackageIssue flags a package that nixpkgs marks insecure or broken on a channel
+type PackageIssue struct {
+	Attr    string // Selectable attr (e.g., "ecdsa")
+	Name    string // Package name (pname) when it differs from Attr
+	Broken  bool   // meta.broken; otherwise marked insecure (meta.knownVulnerabilities)
+	Version string // Version marked insecure, for permittedInsecurePackages
+	Reason  string // Shown when selecting (e.g., "CVE-2024-23342")
 }
 
 // Package represents a Nix package
@@ -14,6 +25,13 @@ type Package struct {
 	NixAttr     string // Nix attribute path (e.g., "pytest", "numpy")
 	Description string
 	Category    string // Display group (e.g., "Machine Learning", "Audio")
+	// Variants maps an accelerator build ("cuda", "cuda-bin", "rocm") to the attr to use instead
+	Variants map[string]string
+	// Channel availability: the first NixOS release with the attr and the first
+	// without it (e.g., "nixos-23.11"); empty means unbounded
+	AddedIn   string
+	RemovedIn string
+	RenamedTo string // Attr that replaces it from RemovedIn on (e.g., "eza" for "exa")
 }
 
 // LanguageVersion represents a specific version of a language
@@ -25,10 +43,37 @@ type LanguageVersion struct {
 
 // Feature represents an optional feature or capability
 type Feature struct {
-	Name        string   // Display name (e.g., "CUDA Support")
-	NixAttrs    []string // Nix attributes to add (e.g., ["cudatoolkit", "cudnn"])
-	Description string   // What this feature provides
-	Languages   []string // Which languages support this (empty = all)
+	Name        string         // Display name (e.g., "CUDA Support")
+	NixAttrs    []string       // Nix attributes to add (e.g., ["cudatoolkit", "cudnn"])
+	Description string         // What this feature provides
+	Languages   []string       // Which languages support this (empty = all)
+	Params      []FeatureParam // Options shown under the feature once it is selected
+}
+
+// FeatureParam represents a configurable option of a feature
+type FeatureParam struct {
+	Key      string   // Key in UserConfig.FeatureParams (e.g., "cudaPackages")
+	Name     string   // Display name (e.g., "CUDA version")
+	Choices  []string // Static choices; the first one is the default
+	FreeText bool     // Typed in by the user instead of picked from Choices
+	Default  string   // Initial value of a free-text parameter
+}
+
+// ShellSnippet represents a named, reusable block of shellHook commands
+type ShellSnippet struct {
+	Name        string   // Display name (e.g., "Install pre-commit hooks")
+	Description string   // What the snippet does
+	Lines       []string // Shell commands, one per line
+	IsDefault   bool     // Enabled unless the user deselects it
+}
+
+// Service represents a local service run by process-compose inside the devShell
+type Service struct {
+	Name        string   // process-compose process name (e.g., "postgres")
+	NixAttr     string   // package providing the server and client binaries
+	Description string   // What the service provides
+	Env         []string // NAME=value pairs exported in the shell; $DEVENV_STATE is the state root
+	Script      []string // Shell lines that initialise state (if needed) and exec the server
 }
 
 // LanguageTemplate represents a preset configuration for a language
@@ -0,0 +1,146 @@
+package nix
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// PackageSwap describes a selected package that is replaced by an
+// accelerator-specific variant in the generated flake.
+type PackageSwap struct {
+	From   string // attr the user selected (e.g., "torch")
+	To     string // attr written to the flake (e.g., "torchWithCuda")
+	Reason string // one-line explanation for the confirmation screen
+}
+
+// ParamChoices returns the choices for a feature parameter on the given
+// channel. The first choice is the default.
+func ParamChoices(param models.FeatureParam, channel models.NixpkgsChannel) []string {
+	if param.Key == ParamCUDAPackages {
+		// The bare set follows whatever CUDA version the channel defaults to
+		return append([]string{"cudaPackages"}, channel.CUDAPackageSets...)
+	}
+	return param.Choices
+}
+
+// DefaultFeatureParams returns the default choice of every parameter of the
+// given features on the given channel.
+func DefaultFeatureParams(features []models.Feature, channel models.NixpkgsChannel) map[string]string {
+	params := make(map[string]string)
+	for _, feature := range features {
+		for _, param := range feature.Params {
+			if param.FreeText {
+				params[param.Key] = param.Default
+				continue
+			}
+			if choices := ParamChoices(param, channel); len(choices) > 0 {
+				params[param.Key] = choices[0]
+			}
+		}
+	}
+	return params
+}
+
+// CUDAVersionLabel turns a versioned set name into a version, e.g.
+// "cudaPackages_12_4" → "12.4". The bare set yields "channel default".
+func CUDAVersionLabel(set string) string {
+	version, ok := strings.CutPrefix(set, "cudaPackages_")
+	if !ok || version == "" {
+		return "channel default"
+	}
+	return strings.ReplaceAll(version, "_", ".")
+}
+
+// AcceleratorSwaps returns the package replacements implied by the enabled
+// accelerator features, in the order the packages were selected. When both
+// CUDA and ROCm are enabled, CUDA variants win.
+func AcceleratorSwaps(config models.UserConfig) []PackageSwap {
+	if !config.UseCUDA && !config.UseROCm {
+		return nil
+	}
+	lang, ok := GetLanguage(config.Language)
+	if !ok {
+		return nil
+	}
+
+	var variants []string
+	if config.UseCUDA {
+		if config.FeatureParams[ParamCUDABuild] == "prebuilt" {
+			variants = append(variants, "cuda-bin")
+		} else {
+			variants = append(variants, "cuda")
+		}
+	}
+	if config.UseROCm {
+		variants = append(variants, "rocm")
+	}
+	version := CUDAVersionLabel(config.FeatureParams[ParamCUDAPackages])
+
+	var swaps []PackageSwap
+	for _, attr := range config.Packages {
+		pkg, ok := findPackage(lang.CommonPackages, attr)
+		if !ok {
+			continue
+		}
+		for _, variant := range variants {
+			to, ok := pkg.Variants[variant]
+			if !ok {
+				continue
+			}
+			var reason string
+			switch variant {
+			case "cuda":
+				reason = fmt.Sprintf("%s is compiled from source against CUDA %s", to, version)
+			case "cuda-bin":
+				reason = fmt.Sprintf("%s is the prebuilt upstream binary with its own CUDA runtime (no local compile)", to)
+			case "rocm":
+				reason = fmt.Sprintf("%s is compiled against the ROCm runtime from rocmPackages", to)
+			}
+			swaps = append(swaps, PackageSwap{From: attr, To: to, Reason: reason})
+			break
+		}
+	}
+	return swaps
+}
+
+// applySwaps replaces swapped attrs in packages, keeping their order.
+func applySwaps(packages []string, swaps []PackageSwap) []string {
+	replaced := make([]string, len(packages))
+	for i, attr := range packages {
+		replaced[i] = attr
+		for _, swap := range swaps {
+			if swap.From == attr {
+				replaced[i] = swap.To
+				break
+			}
+		}
+	}
+	return replaced
+}
+
+// withCUDASet points "cudaPackages.*" attrs at a versioned CUDA set.
+func withCUDASet(attrs []string, set string) []string {
+	if set == "" || set == "cudaPackages" {
+		return attrs
+	}
+	rewritten := make([]string, len(attrs))
+	for i, attr := range attrs {
+		if rest, ok := strings.CutPrefix(attr, "cudaPackages."); ok {
+			attr = set + "." + rest
+		}
+		rewritten[i] = attr
+	}
+	return rewritten
+}
+
+// findPackage looks up a catalog package by its NixAttr.
+func findPackage(packages []models.Package, attr string) (models.Package, bool) {
+	for _, pkg := range packages {
+		if pkg.NixAttr == attr {
+			return pkg, true
+		}
+	}
+	return models.Package{}, false
+}
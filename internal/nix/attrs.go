@@ -0,0 +1,156 @@
+package nix
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// AttrCheck is the result of validating a custom attr against a channel.
+type AttrCheck struct {
+	Attr        string
+	Python      bool     // checked in the Python package set rather than pkgs
+	Known       bool     // the channel has the attr
+	Unchecked   bool     // could not be checked (no complete index and nix eval failed)
+	Reason      string   // why the attr could not be checked
+	Suggestions []string // close attrs of the index when the attr is unknown
+}
+
+// attrEvalTimeout bounds `nix eval`; the first evaluation of a channel downloads it.
+const attrEvalTimeout = 2 * time.Minute
+
+// CheckAttrIndex validates attr against the package index of the channel. It
+// reports false when the index cannot decide, i.e. the attr is missing from an
+// index that only holds the curated catalog; CheckAttrEval decides then.
+func CheckAttrIndex(nixpkgsURL, attr string, python bool) (AttrCheck, bool) {
+	index := LoadIndex(nixpkgsURL)
+	check := AttrCheck{Attr: attr, Python: python}
+	if _, ok := index.Lookup(attr, python); ok {
+		check.Known = true
+		return check, true
+	}
+	if index.Source == CatalogIndexSource {
+		return check, false
+	}
+	check.Suggestions = SuggestAttrs(index, attr, python)
+	return check, true
+}
+
+// CheckAttrEval validates attr with `nix eval --raw <nixpkgs>#<attr>.meta.name`
+// on the configured (possibly pinned) channel. Python attrs are looked up in the
+// package set of the selected Python version. A missing nix binary or an
+// evaluation that fails for other reasons (e.g., no network) leaves the attr unchecked.
+func CheckAttrEval(ctx context.Context, config models.UserConfig, attr string, python bool) AttrCheck {
+	check := AttrCheck{Attr: attr, Python: python}
+	if _, err := exec.LookPath("nix"); err != nil {
+		check.Unchecked = true
+		check.Reason = "nix is not installed"
+		return check
+	}
+
+	flakeURL := config.NixpkgsURL
+	if flakeURL == "" {
+		flakeURL = "github:NixOS/nixpkgs/nixos-unstable"
+	}
+	if config.NixpkgsRev != "" {
+		flakeURL = PinnedURL(flakeURL, config.NixpkgsRev)
+	}
+	path := attr
+	if python {
+		path = config.LanguageVersion + "Packages." + attr
+	}
+	ctx, cancel := context.WithTimeout(ctx, attrEvalTimeout)
+	defer cancel()
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "nix", "eval", "--raw", flakeURL+"#"+path+".meta.name")
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	switch {
+	case err == nil:
+		check.Known = true
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		check.Unchecked = true
+		check.Reason = "nix eval timed out"
+	case strings.Contains(stderr.String(), "does not provide attribute"),
+		strings.Contains(stderr.String(), "attribute '") && strings.Contains(stderr.String(), "missing"):
+		check.Suggestions = SuggestAttrs(LoadIndex(config.NixpkgsURL), attr, python)
+	default:
+		check.Unchecked = true
+		check.Reason = fmt.Sprintf("nix eval failed: %s", lastLine(stderr.String(), err))
+	}
+	return check
+}
+
+// lastLine returns the last non-empty line of a command's stderr, or err.
+func lastLine(output string, err error) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if line := strings.TrimSpace(lines[len(lines)-1]); line != "" {
+		return line
+	}
+	return err.Error()
+}
+
+// maxSuggestions is how many "did you mean" attrs SuggestAttrs returns.
+const maxSuggestions = 3
+
+// SuggestAttrs returns index attrs close to attr: the nearest by edit distance,
+// then fuzzy matches when nothing is close.
+func SuggestAttrs(index *PackageIndex, attr string, python bool) []string {
+	type candidate struct {
+		attr     string
+		distance int
+	}
+	limit := max(2, len(attr)/3)
+	var candidates []candidate
+	for _, entry := range index.Entries {
+		if entry.Python != python {
+			continue
+		}
+		if d := editDistance(strings.ToLower(attr), strings.ToLower(entry.Attr)); d <= limit {
+			candidates = append(candidates, candidate{entry.Attr, d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	var suggestions []string
+	for _, c := range candidates {
+		if len(suggestions) == maxSuggestions {
+			break
+		}
+		suggestions = append(suggestions, c.attr)
+	}
+	if len(suggestions) == 0 {
+		for _, entry := range index.Search(attr, python, maxSuggestions) {
+			suggestions = append(suggestions, entry.Attr)
+		}
+	}
+	return suggestions
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,76 @@
+package nix
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// Availability tells whether a channel has a catalog attr.
+type Availability struct {
+	Available bool
+	RenamedTo string // attr replacing it on the channel, when it was renamed
+	Reason    string // why it is unavailable (e.g., "removed in nixos-23.11")
+}
+
+// PackageAvailability checks the AddedIn/RemovedIn bounds of a catalog
+// package against the channel of nixpkgsURL. Branches that are neither
+// releases nor unstable (e.g., of forks) are assumed to have it.
+func PackageAvailability(nixpkgsURL string, pkg models.Package) Availability {
+	branch := "nixos-unstable"
+	if nixpkgsURL != "" {
+		branch = ChannelBranch(nixpkgsURL)
+	}
+	if pkg.AddedIn != "" {
+		if before, ok := branchBefore(branch, pkg.AddedIn); ok && before {
+			return Availability{Reason: fmt.Sprintf("not in %s, added in %s", branch, pkg.AddedIn)}
+		}
+	}
+	if pkg.RemovedIn != "" {
+		if before, ok := branchBefore(branch, pkg.RemovedIn); ok && !before {
+			availability := Availability{RenamedTo: pkg.RenamedTo, Reason: "removed in " + pkg.RemovedIn}
+			if pkg.RenamedTo != "" {
+				availability.Reason = fmt.Sprintf("renamed to %s in %s", pkg.RenamedTo, pkg.RemovedIn)
+			}
+			return availability
+		}
+	}
+	return Availability{Available: true}
+}
+
+// AttrAvailability looks attr up in the Python package or tool catalog and
+// checks it against the channel of nixpkgsURL. Attrs outside the catalog are
+// assumed to be available.
+func AttrAvailability(nixpkgsURL, attr string, python bool) Availability {
+	catalog := CommonTools
+	if python {
+		catalog = LanguageDefinitions["python"].CommonPackages
+	}
+	idx := slices.IndexFunc(catalog, func(pkg models.Package) bool { return pkg.NixAttr == attr })
+	if idx < 0 {
+		return Availability{Available: true}
+	}
+	return PackageAvailability(nixpkgsURL, catalog[idx])
+}
+
+// channelReleaseRe matches release branches of nixpkgs, including variants
+// such as nixos-24.11-small and nixpkgs-24.11-darwin.
+var channelReleaseRe = regexp.MustCompile(`^nix(?:os|pkgs)-(\d\d\.\d\d)(?:-[a-z]+)?$`)
+
+// branchBefore reports whether branch is an older NixOS release than release.
+// Unstable branches are newer than every release. ok is false when branch is
+// neither a release nor an unstable branch.
+func branchBefore(branch, release string) (before, ok bool) {
+	if strings.Contains(branch, "unstable") {
+		return false, true
+	}
+	match := channelReleaseRe.FindStringSubmatch(branch)
+	if match == nil {
+		return false, false
+	}
+	// YY.MM compares correctly as a string
+	return match[1] < strings.TrimPrefix(release, "nixos-"), true
+}
@@ -0,0 +1,355 @@
+package nix
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// ChannelCacheTTL is how long discovered channels are used before the TUI
+// discovers them again in the background.
+const ChannelCacheTTL = 7 * 24 * time.Hour
+
+// maxStableChannels is how many of the newest NixOS releases discovery keeps.
+const maxStableChannels = 5
+
+// maxChannelEvals is how many channels are evaluated at once; each `nix eval`
+// downloads and evaluates a whole nixpkgs.
+const maxChannelEvals = 2
+
+// channelEvalTimeout bounds the `nix eval` of one channel; the first
+// evaluation of a channel downloads it.
+const channelEvalTimeout = 5 * time.Minute
+
+// branchRefsURL lists the nixos-* branches of nixpkgs.
+const branchRefsURL = "https://api.github.com/repos/NixOS/nixpkgs/git/matching-refs/heads/nixos-"
+
+// channelAttrsExpr selects the versioned Python interpreters and CUDA package
+// sets of a nixpkgs package set. Attrs that throw (removed aliases) are skipped.
+const channelAttrsExpr = `pkgs: builtins.filter (name:
+  builtins.match "python3[0-9]+|cudaPackages_[0-9]+_[0-9]+" name != null
+  && (builtins.tryEval pkgs.${name}).success) (builtins.attrNames pkgs)`
+
+// DiscoveredChannel is what discovery found about one branch.
+type DiscoveredChannel struct {
+	Branch          string   `json:"branch"`          // e.g., "nixos-25.05"
+	PythonVersions  []string `json:"pythonVersions"`  // versioned attrs (e.g., "python313"), oldest first
+	CUDAPackageSets []string `json:"cudaPackageSets"` // e.g., "cudaPackages_12_8"
+}
+
+// ChannelDiscovery is the cached result of DiscoverChannels.
+type ChannelDiscovery struct {
+	DiscoveredAt time.Time           `json:"discoveredAt"`
+	Channels     []DiscoveredChannel `json:"channels"` // nixos-unstable first, then newest release first
+}
+
+// Stale reports whether the discovery is older than ChannelCacheTTL.
+func (d *ChannelDiscovery) Stale() bool {
+	return time.Since(d.DiscoveredAt) > ChannelCacheTTL
+}
+
+// The memoized channel list and the discovery it was built from (nil when the
+// built-in list is used).
+var (
+	channelsMu       sync.Mutex
+	channelsLoaded   bool
+	channelDiscovery *ChannelDiscovery
+	channelList      []models.NixpkgsChannel
+)
+
+// ChannelCachePath returns where discovered channels are cached.
+func ChannelCachePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "manos-nix-template-builder", "channels.json"), nil
+}
+
+// Channels returns the selectable nixpkgs channels: the cached discovery of any
+// age when there is one, otherwise FallbackChannels.
+func Channels() []models.NixpkgsChannel {
+	channels, _ := LoadChannels()
+	return channels
+}
+
+// LoadChannels returns the selectable channels and the discovery they come
+// from, which is nil when the built-in FallbackChannels are used.
+func LoadChannels() ([]models.NixpkgsChannel, *ChannelDiscovery) {
+	channelsMu.Lock()
+	defer channelsMu.Unlock()
+	if !channelsLoaded {
+		channelsLoaded = true
+		channelList = FallbackChannels
+		if discovery, err := readChannelDiscovery(); err == nil && len(discovery.Channels) > 0 {
+			channelDiscovery = discovery
+			channelList = discoveredChannels(discovery)
+		}
+	}
+	return channelList, channelDiscovery
+}
+
+// readChannelDiscovery reads the cached discovery.
+func readChannelDiscovery() (*ChannelDiscovery, error) {
+	path, err := ChannelCachePath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var discovery ChannelDiscovery
+	if err := json.Unmarshal(data, &discovery); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &discovery, nil
+}
+
+// DiscoverChannels lists the NixOS release branches of nixpkgs, evaluates
+// which Python versions and CUDA package sets each provides, and caches the
+// result. Branches whose evaluation fails are left out; it fails when none
+// can be evaluated.
+func DiscoverChannels(ctx context.Context) (*ChannelDiscovery, error) {
+	if _, err := exec.LookPath("nix"); err != nil {
+		return nil, fmt.Errorf("nix is not installed")
+	}
+	branches, err := releaseBranches(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	channels := make([]DiscoveredChannel, len(branches))
+	errs := make([]error, len(branches))
+	sem := make(chan struct{}, maxChannelEvals)
+	var wg sync.WaitGroup
+	for i, branch := range branches {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			channels[i], errs[i] = evalChannel(ctx, branch)
+		})
+	}
+	wg.Wait()
+
+	discovery := &ChannelDiscovery{DiscoveredAt: time.Now().UTC()}
+	for i, channel := range channels {
+		if errs[i] == nil {
+			discovery.Channels = append(discovery.Channels, channel)
+		}
+	}
+	if len(discovery.Channels) == 0 {
+		return nil, fmt.Errorf("failed to evaluate %s: %w", branches[0], errs[0])
+	}
+	if err := SaveChannelDiscovery(discovery); err != nil {
+		return nil, err
+	}
+	return discovery, nil
+}
+
+// SaveChannelDiscovery caches a discovery and makes it the channel list.
+func SaveChannelDiscovery(discovery *ChannelDiscovery) error {
+	path, err := ChannelCachePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(discovery, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+
+	channelsMu.Lock()
+	channelsLoaded = true
+	channelDiscovery = discovery
+	channelList = discoveredChannels(discovery)
+	channelsMu.Unlock()
+	return nil
+}
+
+// releaseBranchRe matches NixOS release branches (e.g., "nixos-25.05"),
+// excluding the -small and -darwin variants.
+var releaseBranchRe = regexp.MustCompile(`^nixos-(\d\d)\.(\d\d)$`)
+
+// releaseBranches returns nixos-unstable and the newest maxStableChannels
+// release branches, newest first, from the GitHub refs API.
+func releaseBranches(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, branchRefsURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list nixpkgs branches: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list nixpkgs branches: %s", resp.Status)
+	}
+
+	var refs []struct {
+		Ref string `json:"ref"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&refs); err != nil {
+		return nil, fmt.Errorf("failed to parse nixpkgs branches: %w", err)
+	}
+	var releases []string
+	for _, ref := range refs {
+		branch := strings.TrimPrefix(ref.Ref, "refs/heads/")
+		if releaseBranchRe.MatchString(branch) {
+			releases = append(releases, branch)
+		}
+	}
+	// YY.MM sorts correctly as a string
+	slices.Sort(releases)
+	slices.Reverse(releases)
+	if len(releases) > maxStableChannels {
+		releases = releases[:maxStableChannels]
+	}
+	return append([]string{"nixos-unstable"}, releases...), nil
+}
+
+// evalChannel finds the Python versions and CUDA package sets of a branch.
+func evalChannel(ctx context.Context, branch string) (DiscoveredChannel, error) {
+	ctx, cancel := context.WithTimeout(ctx, channelEvalTimeout)
+	defer cancel()
+	flakeURL := "github:NixOS/nixpkgs/" + branch + "#legacyPackages.x86_64-linux"
+	output, err := exec.CommandContext(ctx, "nix", "eval", "--json", flakeURL, "--apply", channelAttrsExpr).Output()
+	if err != nil {
+		return DiscoveredChannel{}, fmt.Errorf("nix eval of %s failed: %w", branch, err)
+	}
+	var attrs []string
+	if err := json.Unmarshal(output, &attrs); err != nil {
+		return DiscoveredChannel{}, fmt.Errorf("failed to parse nix eval output: %w", err)
+	}
+
+	channel := DiscoveredChannel{Branch: branch}
+	for _, attr := range attrs {
+		if strings.HasPrefix(attr, "cudaPackages_") {
+			channel.CUDAPackageSets = append(channel.CUDAPackageSets, attr)
+		} else {
+			channel.PythonVersions = append(channel.PythonVersions, attr)
+		}
+	}
+	slices.SortFunc(channel.PythonVersions, comparePythonAttrs)
+	slices.SortFunc(channel.CUDAPackageSets, compareCUDASets)
+	return channel, nil
+}
+
+// discoveredChannels builds the channel list of a discovery. Package issues,
+// which discovery cannot find, come from the fallback entry of the same branch.
+func discoveredChannels(discovery *ChannelDiscovery) []models.NixpkgsChannel {
+	newestRelease := ""
+	for _, channel := range discovery.Channels {
+		if channel.Branch != "nixos-unstable" && channel.Branch > newestRelease {
+			newestRelease = channel.Branch
+		}
+	}
+
+	channels := make([]models.NixpkgsChannel, 0, len(discovery.Channels))
+	for _, discovered := range discovery.Channels {
+		channel := models.NixpkgsChannel{
+			Name:                    discovered.Branch,
+			FlakeURL:                "github:NixOS/nixpkgs/" + discovered.Branch,
+			SupportedPythonVersions: append([]string{"python3"}, discovered.PythonVersions...),
+			CUDAPackageSets:         discovered.CUDAPackageSets,
+		}
+		switch discovered.Branch {
+		case "nixos-unstable":
+			channel.Name += " (recommended)"
+			channel.IsDefault = true
+		case newestRelease:
+			channel.Name += " (stable)"
+		}
+		if fallback, ok := fallbackChannel(channel.FlakeURL); ok {
+			channel.PackageIssues = fallback.PackageIssues
+		}
+		channels = append(channels, channel)
+	}
+	return channels
+}
+
+// fallbackChannel returns the built-in entry of a channel.
+func fallbackChannel(flakeURL string) (models.NixpkgsChannel, bool) {
+	idx := slices.IndexFunc(FallbackChannels, func(channel models.NixpkgsChannel) bool {
+		return channel.FlakeURL == flakeURL
+	})
+	if idx < 0 {
+		return models.NixpkgsChannel{}, false
+	}
+	return FallbackChannels[idx], true
+}
+
+// PythonVersions returns the selectable Python versions of a channel list:
+// the channel default "python3" followed by every versioned attr any channel
+// provides, oldest first.
+func PythonVersions(channels []models.NixpkgsChannel) []models.LanguageVersion {
+	var attrs []string
+	for _, channel := range channels {
+		for _, attr := range channel.SupportedPythonVersions {
+			if attr != "python3" {
+				attrs = append(attrs, attr)
+			}
+		}
+	}
+	attrs = uniqueStrings(attrs)
+	slices.SortFunc(attrs, comparePythonAttrs)
+
+	versions := []models.LanguageVersion{{Name: "Python (latest)", NixAttr: "python3", IsDefault: true}}
+	for _, attr := range attrs {
+		versions = append(versions, models.LanguageVersion{Name: "Python " + pythonVersionName(attr), NixAttr: attr})
+	}
+	return versions
+}
+
+// pythonMinor returns the minor version of a "python3XY" attr, or -1.
+func pythonMinor(attr string) int {
+	minor, err := strconv.Atoi(strings.TrimPrefix(attr, "python3"))
+	if err != nil {
+		return -1
+	}
+	return minor
+}
+
+// pythonVersionName returns the version of a "python3XY" attr: "python313" → "3.13".
+func pythonVersionName(attr string) string {
+	return "3." + strings.TrimPrefix(attr, "python3")
+}
+
+// comparePythonAttrs orders "python3XY" attrs by minor version.
+func comparePythonAttrs(a, b string) int {
+	return pythonMinor(a) - pythonMinor(b)
+}
+
+// compareCUDASets orders "cudaPackages_X_Y" attrs by version.
+func compareCUDASets(a, b string) int {
+	version := func(attr string) (int, int) {
+		major, minor, _ := strings.Cut(strings.TrimPrefix(attr, "cudaPackages_"), "_")
+		x, _ := strconv.Atoi(major)
+		y, _ := strconv.Atoi(minor)
+		return x, y
+	}
+	aMajor, aMinor := version(a)
+	bMajor, bMinor := version(b)
+	if aMajor != bMajor {
+		return aMajor - bMajor
+	}
+	return aMinor - bMinor
+}
@@ -0,0 +1,115 @@
+package nix
+
+import (
+	"slices"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// CheckData is one checks.<system> entry running a tool against the project.
+type CheckData struct {
+	Name    string // check attribute (e.g., "ruff")
+	Command string // shell command run in a writable copy of ./.
+}
+
+// FlakeCheck maps a selectable package or tool to the command its check runs.
+type FlakeCheck struct {
+	Attr    string
+	Command string
+}
+
+// FlakeChecks lists the linters and test runners that get a check, in output order.
+var FlakeChecks = []FlakeCheck{
+	{Attr: "pytest", Command: "pytest || [ $? -eq 5 ]"}, // 5: no tests collected
+	{Attr: "ruff", Command: "ruff check ."},
+	{Attr: "mypy", Command: "mypy ."},
+	{Attr: "black", Command: "black --check --diff ."},
+}
+
+// nixFormatterHooks maps the Nix formatter choices to their git-hooks.nix hooks.
+var nixFormatterHooks = map[string]string{
+	"nixfmt":      "nixfmt-rfc-style",
+	"alejandra":   "alejandra",
+	"nixpkgs-fmt": "nixpkgs-fmt",
+}
+
+// NixFormatterAttr returns the nixpkgs attr of a Nix formatter choice on the
+// channel of nixpkgsURL, or "" for none. The RFC-style nixfmt is
+// nixfmt-rfc-style on 24.05 and 24.11 and nixfmt from 25.05 on, which keeps
+// nixfmt-rfc-style as a deprecated alias; older channels only have the
+// classic nixfmt, under nixfmt.
+func NixFormatterAttr(choice, nixpkgsURL string) string {
+	if _, ok := nixFormatterHooks[choice]; !ok {
+		return ""
+	}
+	if choice != "nixfmt" {
+		return choice
+	}
+	branch := "nixos-unstable"
+	if nixpkgsURL != "" {
+		branch = ChannelBranch(nixpkgsURL)
+	}
+	if before, ok := branchBefore(branch, "nixos-24.05"); ok && before {
+		return "nixfmt"
+	}
+	if before, ok := branchBefore(branch, "nixos-25.05"); ok && before {
+		return "nixfmt-rfc-style"
+	}
+	return "nixfmt"
+}
+
+// flakeChecks returns the checks for the selected linters and test runners,
+// plus the pkgs.* attrs of those selected as tools rather than Python packages.
+// Python packages come from the default shell's environment.
+func flakeChecks(config models.UserConfig) (checks []CheckData, tools []string) {
+	if !config.UseChecks {
+		return nil, nil
+	}
+	for _, check := range FlakeChecks {
+		inPython := slices.Contains(config.Packages, check.Attr)
+		inTools := slices.Contains(config.Tools, check.Attr)
+		if !inPython && !inTools {
+			continue
+		}
+		checks = append(checks, CheckData{Name: check.Attr, Command: check.Command})
+		if !inPython {
+			tools = append(tools, check.Attr)
+		}
+	}
+	return checks, tools
+}
+
+// nixFormatter returns the nixpkgs attr of the chosen formatter, or "" for none.
+func nixFormatter(config models.UserConfig) string {
+	if !config.UseChecks {
+		return ""
+	}
+	return NixFormatterAttr(config.FeatureParams[ParamNixFormatter], config.NixpkgsURL)
+}
+
+// GitHookAttrs lists the linters and formatters that get a git-hooks.nix hook
+// of the same name, in output order.
+var GitHookAttrs = []string{"ruff", "black", "mypy"}
+
+// GitHooks returns the git-hooks.nix hooks to enable: the selected linters and
+// formatters, plus the Nix formatter (the one chosen for checks, else nixfmt).
+// git-hooks.nix names the hooks independently of the channel's formatter attrs.
+func GitHooks(config models.UserConfig) []string {
+	if !config.UseGitHooks {
+		return nil
+	}
+	var hooks []string
+	for _, attr := range GitHookAttrs {
+		if slices.Contains(config.Packages, attr) || slices.Contains(config.Tools, attr) {
+			hooks = append(hooks, attr)
+		}
+	}
+	formatter := "nixfmt"
+	if config.UseChecks {
+		formatter = config.FeatureParams[ParamNixFormatter]
+	}
+	if hook, ok := nixFormatterHooks[formatter]; ok {
+		hooks = append(hooks, hook)
+	}
+	return hooks
+}
@@ -0,0 +1,25 @@
+package nix
+
+import "testing"
+
+func TestNixFormatterAttr(t *testing.T) {
+	tests := []struct {
+		choice, url, want string
+	}{
+		{"nixfmt", "github:NixOS/nixpkgs/nixos-23.05", "nixfmt"},
+		{"nixfmt", "github:NixOS/nixpkgs/nixos-23.11", "nixfmt"},
+		{"nixfmt", "github:NixOS/nixpkgs/nixos-24.05", "nixfmt-rfc-style"},
+		{"nixfmt", "github:NixOS/nixpkgs/nixos-24.11", "nixfmt-rfc-style"},
+		{"nixfmt", "github:NixOS/nixpkgs/nixos-25.05", "nixfmt"},
+		{"nixfmt", "github:NixOS/nixpkgs/nixos-unstable", "nixfmt"},
+		{"nixfmt", "", "nixfmt"},
+		{"alejandra", "github:NixOS/nixpkgs/nixos-23.05", "alejandra"},
+		{"nixpkgs-fmt", "github:NixOS/nixpkgs/nixos-24.11", "nixpkgs-fmt"},
+		{"none", "github:NixOS/nixpkgs/nixos-24.11", ""},
+	}
+	for _, tt := range tests {
+		if got := NixFormatterAttr(tt.choice, tt.url); got != tt.want {
+			t.Errorf("NixFormatterAttr(%q, %q) = %q, want %q", tt.choice, tt.url, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package nix
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
 	"fmt"
 	"os"
@@ -15,62 +16,275 @@ import (
 //go:embed templates/flake_template.nix.tmpl
 var flakeTemplate string
 
+//go:embed templates/uv2nix_template.nix.tmpl
+var uv2nixTemplate string
+
+// commonTemplate holds {{ define }} blocks shared by all flake templates.
+//
+//go:embed templates/common.nix.tmpl
+var commonTemplate string
+
 // FlakeTemplateData holds the data for generating a flake.nix
 type FlakeTemplateData struct {
 	Description    string
 	NixpkgsURL     string
+	NixpkgsPin     string // comment for a pinned NixpkgsURL: branch and revision date
+	Inputs         []InputData // extra flake inputs with their pkgs-<name> bindings
 	PythonVersion  string          // e.g. "python3", "python311"
-	PythonPackages []string        // package attrs for withPackages (no prefix: numpy, pandas, …)
-	SystemPackages []string        // pkgs.* items: zlib, git, cudaPackages.cudatoolkit, …
+	PythonExpr     string          // Python interpreter expression: pkgs.<version>, or python when overridden
+	PythonPackages []string        // package attrs for withPackages (no prefix: numpy, pandas, …); shared ones with several shells
+	Overrides      []PythonOverrideInfo // packageOverrides entries applied via python.override
+	CommonPackages []string        // pkgs.* items shared by all shells (several shells only)
+	PythonEnvs     []PythonEnvData // per-shell Python environments (several shells only)
+	Shells         []ShellData     // devShells; the first one is devShells.default
 	PyPIPackages   []PyPIPackageInfo
 	EnvVars        []string
+	ShellHook      []string // shellHook/profile lines, already escaped for a '' string
 	UseFHS         bool
+	UseVenv        bool
+	NativeLibs     []string // attrs for lib.makeLibraryPath in venv mode
+	Checks         []CheckData
+	CheckEnv       string   // Python environment the checks run in
+	CheckTools     []string // pkgs.* attrs the checks need besides the Python environment
+	Formatter      string   // nixpkgs attr for the formatter output (empty = none)
+	GitHooks       []string // git-hooks.nix hooks to enable (empty = no pre-commit-check)
+	Image          *ImageData // packages.dockerImage (nil = no image)
+	Services       *ServicesData // process-compose services (nil = none)
+
+	// nixpkgs config
+	AllowUnfree      bool     // global allowUnfree instead of allowUnfreePredicate
+	UnfreeNames      []string // package names accepted by allowUnfreePredicate
+	UnfreeLicenses   []string // unfree licenses of UnfreeNames, for the comment
+	InsecurePackages []IssueData // permittedInsecurePackages entries
+	BrokenPackages   []IssueData // package names accepted by allowBrokenPredicate
+	CUDASupport      bool
+	CUDAPackageSet   string // versioned set replacing cudaPackages (empty = channel default)
+	CUDAVersion      string // human-readable version of CUDAPackageSet
+	CUDACapabilities string // single compute capability (empty = nixpkgs default)
+	ROCmSupport      bool
+	ROCmLibs         []string // rocmPackages.* attrs exposed on LD_LIBRARY_PATH in FHS mode
+}
+
+// PythonOverrideInfo holds one rendered packageOverrides entry.
+type PythonOverrideInfo struct {
+	Attr          string
+	Version       string // pinned version (empty = keep nixpkgs version)
+	PyPIName      string // PyPI project fetchPypi downloads from (empty = inherit the pname)
+	HashExpr      string // sdist hash for the pinned version, or pkgs.lib.fakeHash
+	Resolved      bool   // true when HashExpr was fetched from PyPI
+	DisableChecks bool
+	Patches       []string // Nix path expressions
+	RelaxDeps     []string
 }
 
 // GenerateFlake generates a flake.nix file based on user configuration
 func GenerateFlake(config models.UserConfig) (string, error) {
+	return GenerateFlakeContext(context.Background(), config, nil)
+}
+
+// GenerateFlakeContext is GenerateFlake with cancellable PyPI lookups that
+// report their progress (see resolvePyPITasks). It returns ctx.Err() when ctx
+// is cancelled before all lookups finished.
+func GenerateFlakeContext(ctx context.Context, config models.UserConfig, progress func(PyPIProgress)) (string, error) {
 	lang, ok := GetLanguage(config.Language)
 	if !ok {
 		return "", fmt.Errorf("unknown language: %s", config.Language)
 	}
 
-	// Python packages go into python.withPackages — use raw attr names (no prefix)
-	pythonPackages := make([]string, len(config.Packages))
-	copy(pythonPackages, config.Packages)
+	// Python packages go into python.withPackages — use raw attr names (no prefix).
+	// Accelerator features swap some of them for their GPU variants.
+	swaps := AcceleratorSwaps(config)
+	pythonPackages := applySwaps(config.Packages, swaps)
+
+	// Local services run by process-compose; their packages provide the clients too
+	services, serviceAttrs := servicesData(config)
 
 	// System packages: zlib (always, for C-extension compatibility) + tools + CUDA/FHS features
 	systemPackages := []string{"zlib"}
 	systemPackages = append(systemPackages, config.Tools...)
 	systemPackages = append(systemPackages, config.EnabledFeatures...)
+	systemPackages = append(systemPackages, serviceAttrs...)
+	systemPackages = uniqueStrings(systemPackages)
+
+	// Venv mode: wheels in .venv find native libs through LD_LIBRARY_PATH
+	var nativeLibs []string
+	if config.UseVenv {
+		nativeLibs = []string{"zlib", "stdenv.cc.cc.lib"}
+		for _, attr := range systemPackages {
+			if NativeLibraryAttrs[attr] {
+				nativeLibs = append(nativeLibs, attr)
+			}
+		}
+		nativeLibs = uniqueStrings(nativeLibs)
+	}
+
+	// CUDA: pin the chosen CUDA set everywhere cudaPackages is referenced
+	var cudaSet, cudaCapabilities string
+	if anyShellUses(config, FeatureCUDA) {
+		if set := config.FeatureParams[ParamCUDAPackages]; set != "cudaPackages" {
+			cudaSet = set
+		}
+		if capability := config.FeatureParams[ParamCUDACapabilities]; capability != "all" {
+			cudaCapabilities = capability
+		}
+		systemPackages = withCUDASet(systemPackages, cudaSet)
+		nativeLibs = withCUDASet(nativeLibs, cudaSet)
+	}
+
+	// ROCm: FHS shells get the runtime libraries on LD_LIBRARY_PATH
+	var rocmLibs []string
+	if config.UseROCm && config.UseFHS {
+		for _, attr := range systemPackages {
+			if strings.HasPrefix(attr, "rocmPackages.") && NativeLibraryAttrs[attr] {
+				rocmLibs = append(rocmLibs, attr)
+			}
+		}
+	}
+
+	// Extra devShells share what all shells have in common via let bindings
+	// Packages and tools may come from extra inputs via their pkgs-<name> bindings
+	inputs := inputsData(config)
+	specs := []shellSpec{{
+		name:   "default",
+		python: sourcedAttrs(pythonPackages, swaps, config, inputs, true),
+		system: sourcedAttrs(systemPackages, nil, config, inputs, false),
+	}}
+	specs = append(specs, extraShellSpecs(config, cudaSet, serviceAttrs, inputs)...)
+	commonPython, commonSystem, pythonEnvs, shells := layoutShells(specs)
+
+	// nix flake check / nix fmt
+	checks, checkTools := flakeChecks(config)
+	checkTools = sourcedAttrs(checkTools, nil, config, inputs, false)
+
+	// Unfree packages: an allowUnfreePredicate for exactly those the catalog flags
+	unfreeNames, unfreeLicenses := UnfreeAllowances(config)
+
+	// Packages the channel marks insecure or broken, accepted when selected
+	insecurePackages, brokenPackages := packageIssues(config)
+
+	// Package overrides, in selection order. Version pins need the sdist hash from PyPI.
+	// uv2nix takes Python dependencies from uv.lock, so it has neither overrides
+	// nor PyPI packages to resolve.
+	index := LoadIndex(config.NixpkgsURL)
+	var overrides []PythonOverrideInfo
+	for _, attr := range config.Packages {
+		override, ok := config.PackageOverrides[attr]
+		if !ok || override.IsEmpty() || config.UseUv2nix {
+			continue
+		}
+		overrides = append(overrides, overrideInfo(attr, override))
+	}
+	var tasks []pypiTask
+	for i, info := range overrides {
+		if info.Version == "" {
+			continue
+		}
+		project := overrideProject(info.Attr, config.PackageOverrides[info.Attr], index)
+		tasks = append(tasks, pypiTask{name: project + "==" + info.Version, run: func(ctx context.Context) PyPIProgress {
+			return resolveOverrideHash(ctx, project, &overrides[i])
+		}})
+	}
 
 	// Resolve PyPI packages: fetch version + SHA-256 from PyPI JSON API.
 	// Falls back to pkgs.lib.fakeHash on network failure.
-	pypiPackages := make([]PyPIPackageInfo, len(config.PyPIPackages))
-	for i, name := range config.PyPIPackages {
-		pypiPackages[i] = ResolvePyPIPackage(name)
+	var pypiPackages []PyPIPackageInfo
+	if !config.UseUv2nix {
+		pypiPackages = make([]PyPIPackageInfo, len(config.PyPIPackages))
+		tasks = append(tasks, pypiPackageTasks(config.PyPIPackages, config.LanguageVersion, pypiPackages)...)
+	}
+	if err := resolvePyPITasks(ctx, tasks, progress); err != nil {
+		return "", err
+	}
+	// Dependencies missing from nixpkgs are built from PyPI as well
+	pypiPackages, err := resolvePyPIDependencies(ctx, pypiPackages, index, config, progress)
+	if err != nil {
+		return "", err
 	}
 
+	source := flakeTemplate
+	if config.UseUv2nix {
+		source = uv2nixTemplate
+	}
 	tmpl, err := template.New("flake").Funcs(template.FuncMap{
 		"join": strings.Join,
-	}).Parse(flakeTemplate)
+		"pkg":  pkgExpr,
+	}).Parse(source)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template: %w", err)
 	}
+	if _, err := tmpl.Parse(commonTemplate); err != nil {
+		return "", fmt.Errorf("failed to parse template: %w", err)
+	}
 
 	nixpkgsURL := config.NixpkgsURL
 	if nixpkgsURL == "" {
 		nixpkgsURL = "github:NixOS/nixpkgs/nixos-unstable"
 	}
 
+	// A pinned revision replaces the branch; the comment keeps the branch the
+	// revision came from (which may differ from the channel's) and its date
+	var nixpkgsPin string
+	if config.NixpkgsRev != "" {
+		date := config.NixpkgsRevDate
+		if date == "" {
+			date = "unknown date"
+		}
+		branch := config.NixpkgsRevBranch
+		if branch == "" {
+			branch = "nixpkgs"
+		}
+		nixpkgsPin = fmt.Sprintf("%s revision from %s", branch, date)
+		nixpkgsURL = PinnedURL(nixpkgsURL, config.NixpkgsRev)
+	}
+
 	data := FlakeTemplateData{
 		Description:    fmt.Sprintf("%s development environment", lang.Name),
 		NixpkgsURL:     nixpkgsURL,
+		NixpkgsPin:     nixpkgsPin,
+		Inputs:         inputs,
 		PythonVersion:  config.LanguageVersion,
-		PythonPackages: pythonPackages,
-		SystemPackages: systemPackages,
+		PythonExpr:     "pkgs." + config.LanguageVersion,
+		PythonPackages: commonPython,
+		Overrides:      overrides,
+		CommonPackages: commonSystem,
+		PythonEnvs:     pythonEnvs,
+		Shells:         shells,
 		PyPIPackages:   pypiPackages,
 		EnvVars:        config.EnvVars,
+		ShellHook:      escapeShellHook(config.ShellHook),
 		UseFHS:         config.UseFHS,
+		UseVenv:        config.UseVenv,
+		NativeLibs:     nativeLibs,
+		Checks:         checks,
+		CheckTools:     checkTools,
+		Formatter:      nixFormatter(config),
+		GitHooks:       GitHooks(config),
+		Services:       services,
+
+		AllowUnfree:      config.AllowUnfree,
+		UnfreeNames:      unfreeNames,
+		UnfreeLicenses:   unfreeLicenses,
+		InsecurePackages: insecurePackages,
+		BrokenPackages:   brokenPackages,
+		CUDASupport:      config.UseCUDA,
+		CUDAPackageSet:   cudaSet,
+		CUDAVersion:      CUDAVersionLabel(cudaSet),
+		CUDACapabilities: cudaCapabilities,
+		ROCmSupport:      config.UseROCm,
+		ROCmLibs:         rocmLibs,
+	}
+	if len(overrides) > 0 {
+		data.PythonExpr = "python"
+	}
+	data.CheckEnv = shells[0].PythonEnv
+	imageEnv := shells[0].PythonEnv
+	if config.UseUv2nix {
+		data.CheckEnv = "checkEnv"
+		imageEnv = "imageEnv"
+	}
+	data.Image = imageData(config, imageEnv)
+	for i := range data.Shells {
+		data.Shells[i].Root = &data
 	}
 
 	var buf bytes.Buffer
@@ -81,6 +295,107 @@ func GenerateFlake(config models.UserConfig) (string, error) {
 	return buf.String(), nil
 }
 
+// overrideInfo converts patch paths to Nix path expressions. The sdist hash of
+// a version pin is filled in by resolveOverrideHash.
+func overrideInfo(attr string, override models.PackageOverride) PythonOverrideInfo {
+	info := PythonOverrideInfo{
+		Attr:          attr,
+		Version:       override.Version,
+		PyPIName:      override.PyPIName,
+		DisableChecks: override.DisableChecks,
+		RelaxDeps:     override.RelaxDeps,
+	}
+	for _, patch := range override.Patches {
+		info.Patches = append(info.Patches, nixPathExpr(patch))
+	}
+	return info
+}
+
+// overrideProject returns the PyPI project of an overridden package: the name
+// given in the override, else the pname the index records for attr, which
+// fetchPypi inherits, else attr itself.
+func overrideProject(attr string, override models.PackageOverride, index *PackageIndex) string {
+	if override.PyPIName != "" {
+		return override.PyPIName
+	}
+	if entry, ok := index.Lookup(attr, true); ok && entry.PName != "" {
+		return entry.PName
+	}
+	return attr
+}
+
+// resolveOverrideHash looks up the sdist hash of a pinned override version of
+// a PyPI project, falling back to pkgs.lib.fakeHash.
+func resolveOverrideHash(ctx context.Context, project string, info *PythonOverrideInfo) PyPIProgress {
+	hash, err := ResolvePyPISdistHash(ctx, project, info.Version)
+	if err != nil {
+		info.HashExpr = "pkgs.lib.fakeHash"
+		return PyPIProgress{Version: info.Version}
+	}
+	info.HashExpr = hash
+	info.Resolved = true
+	return PyPIProgress{Resolved: true, Version: info.Version}
+}
+
+// nixPathExpr renders a file path relative to flake.nix as a Nix path expression.
+func nixPathExpr(path string) string {
+	if strings.ContainsAny(path, " \"") {
+		return fmt.Sprintf("(./. + %q)", "/"+strings.TrimPrefix(path, "./"))
+	}
+	if strings.HasPrefix(path, "/") || strings.HasPrefix(path, "./") || strings.HasPrefix(path, "../") {
+		return path
+	}
+	return "./" + path
+}
+
+// uniqueStrings returns items with duplicates removed, keeping first occurrences.
+func uniqueStrings(items []string) []string {
+	seen := make(map[string]bool, len(items))
+	unique := make([]string, 0, len(items))
+	for _, item := range items {
+		if !seen[item] {
+			seen[item] = true
+			unique = append(unique, item)
+		}
+	}
+	return unique
+}
+
+// escapeShellHook drops blank lines and escapes the rest for use inside a Nix
+// indented string ('' … ''), where '' and ${ have special meaning.
+func escapeShellHook(lines []string) []string {
+	escaped := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		escaped = append(escaped, escapeIndentedString(line))
+	}
+	return escaped
+}
+
+// escapeIndentedString escapes s in one left-to-right pass: '' becomes ''' and
+// ${ becomes ''${. A ' right before ${ is written as ''\' so that it does not
+// merge with the escape into ''' (a literal '') and leave ${ interpolated.
+func escapeIndentedString(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "''"):
+			b.WriteString("'''")
+			i++
+		case strings.HasPrefix(s[i:], "'${"):
+			b.WriteString(`''\'`)
+		case strings.HasPrefix(s[i:], "${"):
+			b.WriteString("''${")
+			i++
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
 // WriteFlake writes the generated flake content to a file
 func WriteFlake(content string, outputPath string) error {
 	dir := filepath.Dir(outputPath)
@@ -0,0 +1,110 @@
+package nix
+
+import (
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// fakeHTTP serves bodies, keyed by URL path on any host (PyPI, the channel
+// history, ...), to http.DefaultClient for the duration of the test; other
+// paths are 404s. The user cache points at an empty directory meanwhile.
+func fakeHTTP(t *testing.T, bodies map[string]string) {
+	t.Helper()
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	transport := http.DefaultClient.Transport
+	http.DefaultClient.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		body, ok := bodies[r.URL.Path]
+		status := http.StatusOK
+		if !ok {
+			status = http.StatusNotFound
+		}
+		return &http.Response{StatusCode: status, Body: io.NopCloser(strings.NewReader(body)), Request: r}, nil
+	})
+	t.Cleanup(func() { http.DefaultClient.Transport = transport })
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// sdistRelease is a PyPI release response with one sdist, whose hash renders
+// as "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=".
+const sdistRelease = `{"info":{"name":"x","version":"1"},"urls":[{"packagetype":"sdist","url":"https://files/x.tar.gz",` +
+	`"digests":{"sha256":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}]}`
+
+func TestGenerateFlakeOverrideHashes(t *testing.T) {
+	fakeHTTP(t, map[string]string{
+		"/pypi/numpy/1.26.0/json": sdistRelease,
+		"/pypi/PyYAML/6.0/json":   sdistRelease,
+		"/pypi/httpx/0.27.0/json": sdistRelease,
+	})
+	flake, err := GenerateFlake(models.UserConfig{
+		Language:        "python",
+		LanguageVersion: "python312",
+		Packages:        []string{"numpy", "pyyaml", "httpx", "pandas"},
+		PackageOverrides: map[string]models.PackageOverride{
+			"numpy":  {Version: "1.26.0"},
+			"pyyaml": {Version: "6.0", PyPIName: "PyYAML"},
+			"httpx":  {Version: "0.27.0"},
+			"pandas": {Version: "9.9.9"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const resolved = `hash = "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=";`
+	if n := strings.Count(flake, resolved); n != 3 {
+		t.Errorf("%d resolved override hashes, want 3:\n%s", n, flake)
+	}
+	if n := strings.Count(flake, "hash = pkgs.lib.fakeHash;"); n != 1 {
+		t.Errorf("%d fakeHash overrides, want 1:\n%s", n, flake)
+	}
+	if !strings.Contains(flake, `pname = "PyYAML";`) {
+		t.Errorf("PyPI name not passed to fetchPypi:\n%s", flake)
+	}
+}
+
+func TestEscapeShellHook(t *testing.T) {
+	tests := []struct {
+		line, want string
+	}{
+		{`echo hello`, `echo hello`},
+		{`echo ${HOME}`, `echo ''${HOME}`},
+		{`echo ''`, `echo '''`},
+		{`echo '${HOME}'`, `echo ''\'''${HOME}'`},
+		{`echo ''${HOME}`, `echo '''''${HOME}`},
+		{`echo '''`, `echo ''''`},
+		{`echo $HOME 'it''s'`, `echo $HOME 'it'''s'`},
+	}
+	for _, tt := range tests {
+		got := escapeShellHook([]string{tt.line})
+		if !slices.Equal(got, []string{tt.want}) {
+			t.Errorf("escapeShellHook(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+	if got := escapeShellHook([]string{"", "  ", "ls"}); !slices.Equal(got, []string{"ls"}) {
+		t.Errorf("blank lines kept: %q", got)
+	}
+}
+
+func TestGenerateFlakeSwappedSource(t *testing.T) {
+	flake, err := GenerateFlake(models.UserConfig{
+		Language:        "python",
+		LanguageVersion: "python312",
+		Packages:        []string{"torch"},
+		UseCUDA:         true,
+		Inputs:          []models.FlakeInput{{Name: "ml", URL: "github:example/ml-flake"}},
+		PackageSources:  map[string]string{"torch": "ml"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(flake, "pkgs-ml.torchWithCuda") {
+		t.Errorf("source of torch not kept for torchWithCuda:\n%s", flake)
+	}
+}
@@ -0,0 +1,141 @@
+package nix
+
+import (
+	"errors"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// ImageData holds the packages.dockerImage part of the template data.
+type ImageData struct {
+	Name          string
+	Nix2container bool     // build with nix2container instead of dockerTools.buildLayeredImage
+	PythonEnv     string   // binding of the Python environment copied into the image
+	Entrypoint    []string // entrypoint argv, already quoted as Nix strings
+	Ports         []string // exposed ports (e.g., "8000/tcp")
+	Env           []string // NAME= entries for the env vars of the shell
+}
+
+// imageNameRe matches characters not allowed in an image name.
+var imageNameRe = regexp.MustCompile(`[^a-z0-9._-]+`)
+
+// imageData builds the dockerImage settings, or returns nil when the image
+// feature is off. pythonEnv is the let binding holding the Python environment.
+func imageData(config models.UserConfig, pythonEnv string) *ImageData {
+	if !config.UseImage {
+		return nil
+	}
+	image := &ImageData{
+		Name:          ImageName(config.OutputPath),
+		Nix2container: config.FeatureParams[ParamImageBuilder] == "nix2container",
+		PythonEnv:     pythonEnv,
+	}
+	// A malformed entrypoint is reported by Validate; keep the words parsed so far
+	args, _ := splitShellWords(config.FeatureParams[ParamImageEntrypoint])
+	for _, arg := range args {
+		image.Entrypoint = append(image.Entrypoint, nixString(arg))
+	}
+	for _, port := range strings.FieldsFunc(config.FeatureParams[ParamImagePorts], func(r rune) bool {
+		return r == ',' || r == ' '
+	}) {
+		if !strings.Contains(port, "/") {
+			port += "/tcp"
+		}
+		image.Ports = append(image.Ports, port)
+	}
+	for _, name := range config.EnvVars {
+		image.Env = append(image.Env, name+"=")
+	}
+	return image
+}
+
+// errUnterminatedQuote is returned by splitShellWords for an unclosed quote.
+var errUnterminatedQuote = errors.New("unterminated quote")
+
+// splitShellWords splits a command line into arguments the way a POSIX shell
+// does, without expansions: whitespace separates words, single quotes keep
+// everything literal, and double quotes and backslashes escape as in sh.
+func splitShellWords(s string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+			continue
+		case c == '\\':
+			i++
+			if i == len(s) || s[i] == '\n' {
+				continue // line continuation
+			}
+			word.WriteByte(s[i])
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return words, errUnterminatedQuote
+			}
+			word.WriteString(s[i+1 : i+1+end])
+			i += end + 1
+		case c == '"':
+			i++
+			for ; i < len(s) && s[i] != '"'; i++ {
+				// Inside double quotes a backslash only escapes $, `, ", \ and newline
+				if s[i] == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\\n", s[i+1]) >= 0 {
+					i++
+					if s[i] == '\n' {
+						continue
+					}
+				}
+				word.WriteByte(s[i])
+			}
+			if i == len(s) {
+				return words, errUnterminatedQuote
+			}
+		default:
+			word.WriteByte(c)
+		}
+		inWord = true
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// ImageName derives the image name from the directory flake.nix is written to.
+func ImageName(outputPath string) string {
+	name := "app"
+	if abs, err := filepath.Abs(outputPath); err == nil {
+		name = filepath.Base(filepath.Dir(abs))
+	}
+	name = strings.Trim(imageNameRe.ReplaceAllString(strings.ToLower(name), "-"), "-._")
+	if name == "" {
+		return "app"
+	}
+	return name
+}
+
+// nixString quotes s as a Nix double-quoted string.
+func nixString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "${", `\${`)
+	return `"` + s + `"`
+}
+
+// ImageBuildCommand returns the command that builds or loads the image.
+func ImageBuildCommand(config models.UserConfig) string {
+	if config.FeatureParams[ParamImageBuilder] == "nix2container" {
+		return "nix run .#dockerImage.copyToDockerDaemon"
+	}
+	return "nix build .#dockerImage && docker load < result"
+}
@@ -0,0 +1,33 @@
+package nix
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSplitShellWords(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+		err  bool
+	}{
+		{`python main.py`, []string{"python", "main.py"}, false},
+		{`  uvicorn  app:app   --port 8000 `, []string{"uvicorn", "app:app", "--port", "8000"}, false},
+		{`python -c 'print("hi there")'`, []string{"python", "-c", `print("hi there")`}, false},
+		{`sh -c "echo \"a b\" \$HOME"`, []string{"sh", "-c", `echo "a b" $HOME`}, false},
+		{`echo "a\nb"`, []string{"echo", `a\nb`}, false},
+		{`echo a\ b`, []string{"echo", "a b"}, false},
+		{`echo ''`, []string{"echo", ""}, false},
+		{`echo pre'fix'"ed"`, []string{"echo", "prefixed"}, false},
+		{"echo a \\\n b", []string{"echo", "a", "b"}, false},
+		{`echo 'open`, []string{"echo"}, true},
+		{`echo "open`, []string{"echo"}, true},
+		{``, nil, false},
+	}
+	for _, tt := range tests {
+		got, err := splitShellWords(tt.line)
+		if (err != nil) != tt.err || !slices.Equal(got, tt.want) {
+			t.Errorf("splitShellWords(%q) = %q, %v; want %q, error %v", tt.line, got, err, tt.want, tt.err)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package nix
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sahilm/fuzzy"
+)
+
+// IndexEntry is one package of the offline nixpkgs index.
+type IndexEntry struct {
+	Attr        string `json:"attr"`             // attr in pkgs, or in the Python package set when Python is set
+	PName       string `json:"pname,omitempty"`  // derivation pname, without the "python3.12-" prefix of Python packages
+	Python      bool   `json:"python,omitempty"` // member of python3Packages
+	Version     string `json:"version,omitempty"`
+	Description string `json:"description,omitempty"`
+	License     string `json:"license,omitempty"` // short names, comma separated
+	Broken      bool   `json:"broken,omitempty"`
+	Unfree      bool   `json:"unfree,omitempty"`
+}
+
+// PackageIndex is the searchable package list of one channel.
+type PackageIndex struct {
+	Branch  string       `json:"branch"`  // e.g., "nixos-24.11"
+	Source  string       `json:"source"`  // how the index was built (e.g., "nix-env -qaP --json --meta")
+	Entries []IndexEntry `json:"entries"` // sorted by attr, Python packages first
+}
+
+// CatalogIndexSource is the Source of the index built from the curated catalog.
+const CatalogIndexSource = "built-in catalog"
+
+// indexCache memoizes loaded indexes by branch.
+var (
+	indexMu    sync.Mutex
+	indexCache = make(map[string]*PackageIndex)
+)
+
+// IndexPath returns where the cached index of a branch is stored.
+func IndexPath(branch string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "manos-nix-template-builder", "index-"+branch+".json"), nil
+}
+
+// LoadIndex returns the package index of the channel of nixpkgsURL: the cached
+// one written by RegenerateIndex when present, otherwise the index embedded in
+// the catalog (curated packages and tools only, without versions).
+func LoadIndex(nixpkgsURL string) *PackageIndex {
+	branch := "nixos-unstable"
+	if nixpkgsURL != "" {
+		branch = ChannelBranch(nixpkgsURL)
+	}
+
+	indexMu.Lock()
+	defer indexMu.Unlock()
+	if index, ok := indexCache[branch]; ok {
+		return index
+	}
+	index, err := readIndex(branch)
+	if err != nil {
+		index = catalogIndex(branch)
+	}
+	indexCache[branch] = index
+	return index
+}
+
+// readIndex reads the cached index of a branch.
+func readIndex(branch string) (*PackageIndex, error) {
+	path, err := IndexPath(branch)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var index PackageIndex
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return &index, nil
+}
+
+// catalogIndex builds the embedded index from the curated catalog.
+func catalogIndex(branch string) *PackageIndex {
+	index := &PackageIndex{Branch: branch, Source: CatalogIndexSource}
+	for _, lang := range LanguageDefinitions {
+		for _, pkg := range lang.CommonPackages {
+			index.Entries = append(index.Entries, catalogEntry(pkg.NixAttr, pkg.Description, true))
+		}
+	}
+	for _, tool := range CommonTools {
+		index.Entries = append(index.Entries, catalogEntry(tool.NixAttr, tool.Description, false))
+	}
+	index.Entries = sortIndex(index.Entries)
+	return index
+}
+
+// catalogEntry returns the index entry of a catalog package; the unfree flag
+// comes from UnfreePackages.
+func catalogEntry(attr, description string, python bool) IndexEntry {
+	entry := IndexEntry{Attr: attr, Python: python, Description: description}
+	if unfree, ok := UnfreePackages[attr]; ok {
+		entry.Unfree = true
+		entry.License = unfree.License
+	}
+	return entry
+}
+
+// sortIndex orders entries by attr, Python packages first, and drops duplicates.
+func sortIndex(entries []IndexEntry) []IndexEntry {
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Python != entries[j].Python {
+			return entries[i].Python
+		}
+		return entries[i].Attr < entries[j].Attr
+	})
+	unique := entries[:0]
+	for i, entry := range entries {
+		if i > 0 && entry.Attr == entries[i-1].Attr && entry.Python == entries[i-1].Python {
+			continue
+		}
+		unique = append(unique, entry)
+	}
+	return unique
+}
+
+// Lookup returns the entry of attr in the Python package set or in pkgs.
+func (index *PackageIndex) Lookup(attr string, python bool) (IndexEntry, bool) {
+	i := sort.Search(len(index.Entries), func(i int) bool {
+		entry := index.Entries[i]
+		if entry.Python != python {
+			return !entry.Python
+		}
+		return entry.Attr >= attr
+	})
+	if i < len(index.Entries) && index.Entries[i].Attr == attr && index.Entries[i].Python == python {
+		return index.Entries[i], true
+	}
+	return IndexEntry{}, false
+}
+
+// indexSource adapts index entries of one package set to fuzzy.Source.
+type indexSource []IndexEntry
+
+func (s indexSource) String(i int) string { return s[i].Attr }
+func (s indexSource) Len() int            { return len(s) }
+
+// Search fuzzy-matches query against the attrs of the Python package set or
+// of pkgs and returns at most limit entries, best match first.
+func (index *PackageIndex) Search(query string, python bool, limit int) []IndexEntry {
+	if query == "" {
+		return nil
+	}
+	var source indexSource
+	for _, entry := range index.Entries {
+		if entry.Python == python {
+			source = append(source, entry)
+		}
+	}
+	matches := fuzzy.FindFrom(query, source)
+	results := make([]IndexEntry, 0, min(limit, len(matches)))
+	for _, match := range matches {
+		if len(results) == limit {
+			break
+		}
+		results = append(results, source[match.Index])
+	}
+	return results
+}
+
+// RegenerateIndex rebuilds the index of a branch with nix-env and caches it on
+// disk. It evaluates all of nixpkgs, so it takes a while.
+func RegenerateIndex(branch string) (*PackageIndex, error) {
+	if _, err := exec.LookPath("nix-env"); err != nil {
+		return nil, fmt.Errorf("nix-env not found: %w", err)
+	}
+	nixpkgs := "https://github.com/NixOS/nixpkgs/archive/refs/heads/" + branch + ".tar.gz"
+
+	var entries []IndexEntry
+	for _, args := range [][]string{
+		{"-qaP", "--json", "--meta", "-f", nixpkgs},
+		{"-qaP", "--json", "--meta", "-f", nixpkgs, "-A", "python3Packages"},
+	} {
+		output, err := exec.Command("nix-env", args...).Output()
+		if err != nil {
+			return nil, fmt.Errorf("nix-env %s failed: %w", strings.Join(args, " "), err)
+		}
+		parsed, err := ParseIndexJSON(output)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, parsed...)
+	}
+	return SaveIndex(branch, "nix-env -qaP --json --meta", entries)
+}
+
+// ImportIndex builds the index of a branch from saved `nix search --json` or
+// `nix-env -qaP --json --meta` output and caches it on disk.
+func ImportIndex(branch, path string) (*PackageIndex, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := ParseIndexJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return SaveIndex(branch, "imported from "+filepath.Base(path), entries)
+}
+
+// SaveIndex writes the index of a branch to the cache directory.
+func SaveIndex(branch, source string, entries []IndexEntry) (*PackageIndex, error) {
+	index := &PackageIndex{Branch: branch, Source: source, Entries: sortIndex(entries)}
+	path, err := IndexPath(branch)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(index)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return nil, err
+	}
+
+	indexMu.Lock()
+	indexCache[branch] = index
+	indexMu.Unlock()
+	return index, nil
+}
+
+// indexPackage is one value of `nix search --json` or `nix-env -qaP --json --meta`.
+type indexPackage struct {
+	PName       string `json:"pname"`
+	Version     string `json:"version"`
+	Description string `json:"description"` // nix search
+	Meta        struct {
+		Description string          `json:"description"`
+		License     json.RawMessage `json:"license"`
+		Broken      bool            `json:"broken"`
+		Unfree      bool            `json:"unfree"`
+	} `json:"meta"` // nix-env --meta
+}
+
+// pythonSetRe matches the Python package set segment of an attr path.
+var pythonSetRe = regexp.MustCompile(`^python3\d*Packages$`)
+
+// pythonPrefixRe matches the interpreter prefix of Python package pnames.
+var pythonPrefixRe = regexp.MustCompile(`^python3\.\d+-`)
+
+// ParseIndexJSON parses `nix search --json` output (keys like
+// "legacyPackages.x86_64-linux.ripgrep") or `nix-env -qaP --json --meta` output
+// (keys like "ripgrep" or "python3Packages.numpy") into index entries. Attrs
+// nested in other sets than a Python package set are skipped.
+func ParseIndexJSON(data []byte) ([]IndexEntry, error) {
+	var packages map[string]indexPackage
+	if err := json.Unmarshal(data, &packages); err != nil {
+		return nil, fmt.Errorf("failed to parse package list: %w", err)
+	}
+
+	entries := make([]IndexEntry, 0, len(packages))
+	for key, pkg := range packages {
+		path := strings.Split(key, ".")
+		if path[0] == "legacyPackages" && len(path) > 2 {
+			path = path[2:] // legacyPackages.<system>.
+		}
+		entry := IndexEntry{Attr: path[len(path)-1], PName: pythonPrefixRe.ReplaceAllString(pkg.PName, ""), Version: pkg.Version}
+		switch {
+		case len(path) == 1:
+		case len(path) == 2 && pythonSetRe.MatchString(path[0]):
+			entry.Python = true
+		default:
+			continue
+		}
+		entry.Description = pkg.Description
+		if pkg.Meta.Description != "" {
+			entry.Description = pkg.Meta.Description
+		}
+		entry.License = licenseNames(pkg.Meta.License)
+		entry.Broken = pkg.Meta.Broken
+		entry.Unfree = pkg.Meta.Unfree
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// nixLicense is a license attrset from nixpkgs' lib.licenses.
+type nixLicense struct {
+	ShortName string `json:"shortName"`
+	SpdxID    string `json:"spdxId"`
+}
+
+// licenseNames flattens meta.license (a license, a list of them, or a string)
+// into comma-separated short names.
+func licenseNames(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var licenses []nixLicense
+	var single nixLicense
+	var name string
+	switch {
+	case json.Unmarshal(raw, &licenses) == nil:
+	case json.Unmarshal(raw, &single) == nil:
+		licenses = []nixLicense{single}
+	case json.Unmarshal(raw, &name) == nil:
+		return name
+	}
+	names := make([]string, 0, len(licenses))
+	for _, license := range licenses {
+		if license.SpdxID != "" {
+			names = append(names, license.SpdxID)
+		} else if license.ShortName != "" {
+			names = append(names, license.ShortName)
+		}
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,112 @@
+package nix
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// InputData holds one extra flake input of the template data.
+type InputData struct {
+	Name    string
+	URL     string
+	Follows string
+	Pkgs    string // let binding for the input's packages (pkgs-<name>)
+	Nixpkgs bool   // true when the input is a nixpkgs checkout, imported like pkgs
+}
+
+// ReservedInputs are input names the generated flakes already use.
+var ReservedInputs = []string{
+	"self", "nixpkgs", "flake-utils", "nix2container",
+	"pyproject-nix", "uv2nix", "pyproject-build-systems", "git-hooks",
+}
+
+// inputNameRe matches names usable as flake inputs and Nix identifiers.
+var inputNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// ParseFlakeInput parses "name url [follows]" as typed in the wizard.
+func ParseFlakeInput(line string, existing []models.FlakeInput) (models.FlakeInput, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return models.FlakeInput{}, fmt.Errorf("expected: name url [follows]")
+	}
+	input := models.FlakeInput{Name: fields[0], URL: fields[1]}
+	if len(fields) == 3 {
+		input.Follows = fields[2]
+	}
+
+	if !inputNameRe.MatchString(input.Name) {
+		return input, fmt.Errorf("invalid input name %q", input.Name)
+	}
+	names := slices.Clone(ReservedInputs)
+	for _, other := range existing {
+		names = append(names, other.Name)
+	}
+	if slices.Contains(names, input.Name) {
+		return input, fmt.Errorf("input %q already exists", input.Name)
+	}
+	if input.Follows != "" && input.Follows != "nixpkgs" && !slices.ContainsFunc(existing, func(other models.FlakeInput) bool {
+		return other.Name == input.Follows
+	}) {
+		return input, fmt.Errorf("unknown input %q to follow", input.Follows)
+	}
+	return input, nil
+}
+
+// IsNixpkgsURL reports whether a flake URL points at a nixpkgs checkout.
+func IsNixpkgsURL(url string) bool {
+	lower := strings.ToLower(url)
+	return strings.Contains(lower, "nixos/nixpkgs") || lower == "nixpkgs" || strings.HasPrefix(lower, "nixpkgs/") || strings.HasPrefix(lower, "flake:nixpkgs")
+}
+
+// inputsData converts the configured inputs for the template.
+func inputsData(config models.UserConfig) []InputData {
+	inputs := make([]InputData, 0, len(config.Inputs))
+	for _, input := range config.Inputs {
+		inputs = append(inputs, InputData{
+			Name:    input.Name,
+			URL:     input.URL,
+			Follows: input.Follows,
+			Pkgs:    "pkgs-" + input.Name,
+			Nixpkgs: IsNixpkgsURL(input.URL),
+		})
+	}
+	return inputs
+}
+
+// sourcedAttrs qualifies attrs that come from an extra input with the input's
+// pkgs binding. The source of a swapped attr is looked up under the attr it
+// replaced. Python packages from nixpkgs inputs (rejected by Validate, since
+// they would be built for another interpreter) and attrs without a (known)
+// source are returned unchanged.
+func sourcedAttrs(attrs []string, swaps []PackageSwap, config models.UserConfig, inputs []InputData, python bool) []string {
+	if len(config.PackageSources) == 0 {
+		return attrs
+	}
+	qualified := make([]string, len(attrs))
+	for i, attr := range attrs {
+		qualified[i] = attr
+		source, ok := config.PackageSources[attr]
+		if idx := slices.IndexFunc(swaps, func(swap PackageSwap) bool { return swap.To == attr }); !ok && idx >= 0 {
+			source = config.PackageSources[swaps[idx].From]
+		}
+		idx := slices.IndexFunc(inputs, func(input InputData) bool { return input.Name == source })
+		if idx < 0 || python && inputs[idx].Nixpkgs {
+			continue
+		}
+		qualified[i] = inputs[idx].Pkgs + "." + attr
+	}
+	return qualified
+}
+
+// pkgExpr renders a system package for a list outside `with pkgs;`: plain
+// attrs get the pkgs. prefix, attrs qualified by sourcedAttrs are kept.
+func pkgExpr(attr string) string {
+	if strings.HasPrefix(attr, "pkgs-") {
+		return attr
+	}
+	return "pkgs." + attr
+}
@@ -0,0 +1,96 @@
+package nix
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// IssueData is one permittedInsecurePackages or allowBrokenPredicate entry.
+type IssueData struct {
+	Name   string // name-version (insecure) or package name (broken)
+	Reason string
+}
+
+// channelFor returns the catalog channel whose flake URL is nixpkgsURL.
+// An empty URL means the default channel.
+func channelFor(nixpkgsURL string) (models.NixpkgsChannel, bool) {
+	for _, channel := range Channels() {
+		if channel.FlakeURL == nixpkgsURL || (nixpkgsURL == "" && channel.IsDefault) {
+			return channel, true
+		}
+	}
+	return models.NixpkgsChannel{}, false
+}
+
+// ChannelIssue returns the catalog's insecure or broken flag for attr on the
+// channel of nixpkgsURL.
+func ChannelIssue(nixpkgsURL, attr string) (models.PackageIssue, bool) {
+	channel, ok := channelFor(nixpkgsURL)
+	if !ok {
+		return models.PackageIssue{}, false
+	}
+	idx := slices.IndexFunc(channel.PackageIssues, func(issue models.PackageIssue) bool {
+		return issue.Attr == attr
+	})
+	if idx < 0 {
+		return models.PackageIssue{}, false
+	}
+	return channel.PackageIssues[idx], true
+}
+
+// SelectedIssues returns the flagged packages among the selection of all
+// shells, in selection order.
+func SelectedIssues(config models.UserConfig) []models.PackageIssue {
+	attrs := slices.Concat(config.Packages, config.Tools)
+	for _, profile := range config.ExtraShells {
+		attrs = append(attrs, profile.Packages...)
+		attrs = append(attrs, profile.Tools...)
+	}
+
+	var issues []models.PackageIssue
+	for _, attr := range uniqueStrings(attrs) {
+		if issue, ok := ChannelIssue(config.NixpkgsURL, attr); ok {
+			issues = append(issues, issue)
+		}
+	}
+	return issues
+}
+
+// packageIssues returns the permittedInsecurePackages and allowBrokenPredicate
+// entries for the flagged packages of the selection. Insecure Python packages
+// carry the interpreter prefix of their derivation name (e.g., "python3.11-").
+func packageIssues(config models.UserConfig) (insecure, broken []IssueData) {
+	python := slices.Clone(config.Packages)
+	for _, profile := range config.ExtraShells {
+		python = append(python, profile.Packages...)
+	}
+
+	for _, issue := range SelectedIssues(config) {
+		name := issue.Name
+		if name == "" {
+			name = issue.Attr
+		}
+		if issue.Broken {
+			broken = append(broken, IssueData{Name: name, Reason: issue.Reason})
+			continue
+		}
+		name += "-" + issue.Version
+		if slices.Contains(python, issue.Attr) {
+			name = pythonLibPrefix(config.LanguageVersion) + "-" + name
+		}
+		insecure = append(insecure, IssueData{Name: name, Reason: issue.Reason})
+	}
+	return insecure, broken
+}
+
+// pythonLibPrefix returns the prefix of Python package derivation names:
+// "python311" → "python3.11". The unversioned python3 depends on the channel,
+// so it is read from nixpkgs at evaluation time.
+func pythonLibPrefix(version string) string {
+	if minor, ok := strings.CutPrefix(version, "python3"); ok && minor != "" {
+		return "python3." + minor
+	}
+	return "${nixpkgs.legacyPackages.${system}." + version + ".libPrefix}"
+}
@@ -0,0 +1,430 @@
+package nix
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// markerEnv is the environment PEP 508 markers of a dependency are evaluated
+// in: the interpreter of the flake and the extras requested of the package.
+type markerEnv struct {
+	python string   // Python attr, e.g. "python312"; "python3" leaves the version to Nix
+	extras []string // normalized requested extras
+}
+
+// systemMarkers are the values of the platform markers on each default system
+// (a CPython interpreter is assumed).
+var systemMarkers = map[string]map[string]string{
+	"x86_64-linux":   {"sys_platform": "linux", "platform_machine": "x86_64", "platform_system": "Linux"},
+	"aarch64-linux":  {"sys_platform": "linux", "platform_machine": "aarch64", "platform_system": "Linux"},
+	"x86_64-darwin":  {"sys_platform": "darwin", "platform_machine": "x86_64", "platform_system": "Darwin"},
+	"aarch64-darwin": {"sys_platform": "darwin", "platform_machine": "arm64", "platform_system": "Darwin"},
+}
+
+// markerValue is a marker evaluated on one system: a constant, or a Nix
+// expression when it depends on the version of the unversioned python3.
+type markerValue struct {
+	value bool
+	nix   string // set when the value is only known at evaluation time
+}
+
+var (
+	markerTrue  = markerValue{value: true}
+	markerFalse = markerValue{}
+)
+
+// markerAnd and markerOr combine marker values, folding away constants.
+func markerAnd(a, b markerValue) markerValue {
+	switch {
+	case a.nix == "" && !a.value, b.nix == "" && !b.value:
+		return markerFalse
+	case a.nix == "":
+		return b
+	case b.nix == "":
+		return a
+	}
+	return markerValue{nix: fmt.Sprintf("(%s && %s)", a.nix, b.nix)}
+}
+
+func markerOr(a, b markerValue) markerValue {
+	switch {
+	case a.nix == "" && a.value, b.nix == "" && b.value:
+		return markerTrue
+	case a.nix == "":
+		return b
+	case b.nix == "":
+		return a
+	}
+	return markerValue{nix: fmt.Sprintf("(%s || %s)", a.nix, b.nix)}
+}
+
+// PyPIDepGroup is a group of dependencies needed under the same Nix condition.
+type PyPIDepGroup struct {
+	Condition string
+	Attrs     []string
+}
+
+// UnconditionalDeps returns the RuntimeDeps needed everywhere.
+func (p PyPIPackageInfo) UnconditionalDeps() []string {
+	var attrs []string
+	for _, attr := range p.RuntimeDeps {
+		if _, ok := p.DepConditions[attr]; !ok {
+			attrs = append(attrs, attr)
+		}
+	}
+	return attrs
+}
+
+// ConditionalDeps returns the other RuntimeDeps, grouped by condition.
+func (p PyPIPackageInfo) ConditionalDeps() []PyPIDepGroup {
+	var groups []PyPIDepGroup
+	for _, attr := range p.RuntimeDeps {
+		condition, ok := p.DepConditions[attr]
+		if !ok {
+			continue
+		}
+		i := slices.IndexFunc(groups, func(g PyPIDepGroup) bool { return g.Condition == condition })
+		if i < 0 {
+			groups = append(groups, PyPIDepGroup{Condition: condition})
+			i = len(groups) - 1
+		}
+		groups[i].Attrs = append(groups[i].Attrs, attr)
+	}
+	return groups
+}
+
+// markerCondition evaluates a PEP 508 marker on every default system and
+// returns the Nix condition under which the dependency is needed: "" when it
+// always is. ok is false when it never is.
+func markerCondition(marker string, env markerEnv) (condition string, ok bool, err error) {
+	tree, err := parseMarker(marker)
+	if err != nil {
+		return "", false, err
+	}
+	extras := env.extras
+	if len(extras) == 0 {
+		extras = []string{""}
+	}
+
+	// Group the systems by result, e.g. {true: linux systems, false: darwin systems}
+	var results []markerValue
+	var systems [][]string
+	for _, system := range defaultSystems {
+		result := markerFalse
+		for _, extra := range extras {
+			value, err := tree.eval(system, env.python, extra)
+			if err != nil {
+				return "", false, err
+			}
+			result = markerOr(result, value)
+		}
+		i := slices.Index(results, result)
+		if i < 0 {
+			results = append(results, result)
+			systems = append(systems, nil)
+			i = len(results) - 1
+		}
+		systems[i] = append(systems[i], system)
+	}
+
+	var conditions []string
+	for i, result := range results {
+		if result == markerFalse {
+			continue
+		}
+		var parts []string
+		if cond := systemCondition(systems[i]); cond != "" {
+			parts = append(parts, cond)
+		}
+		if result.nix != "" {
+			parts = append(parts, result.nix)
+		}
+		if len(parts) == 0 {
+			return "", true, nil // holds everywhere
+		}
+		conditions = append(conditions, strings.Join(parts, " && "))
+	}
+	switch len(conditions) {
+	case 0:
+		return "", false, nil
+	case 1:
+		return conditions[0], true, nil
+	}
+	for i, cond := range conditions {
+		conditions[i] = "(" + cond + ")"
+	}
+	return strings.Join(conditions, " || "), true, nil
+}
+
+// systemCondition returns a Nix condition matching the given default systems.
+func systemCondition(systems []string) string {
+	switch {
+	case len(systems) == len(defaultSystems):
+		return ""
+	case slices.Equal(systems, []string{"x86_64-linux", "aarch64-linux"}):
+		return "pkgs.stdenv.isLinux"
+	case slices.Equal(systems, []string{"x86_64-darwin", "aarch64-darwin"}):
+		return "pkgs.stdenv.isDarwin"
+	case len(systems) == 1:
+		return fmt.Sprintf("system == %q", systems[0])
+	}
+	quoted := make([]string, len(systems))
+	for i, system := range systems {
+		quoted[i] = fmt.Sprintf("%q", system)
+	}
+	return fmt.Sprintf("builtins.elem system [ %s ]", strings.Join(quoted, " "))
+}
+
+// markerNode is a parsed marker: an "and"/"or" of two nodes or a comparison.
+type markerNode struct {
+	op          string // "and", "or", or a comparison operator
+	left, right *markerNode
+	lhs, rhs    markerOperand
+}
+
+// markerOperand is a quoted string or a marker variable.
+type markerOperand struct {
+	variable string
+	literal  string
+}
+
+// markerVariables are the variables the evaluator knows.
+var markerVariables = []string{
+	"python_version", "python_full_version", "sys_platform", "platform_machine", "platform_system",
+	"os_name", "implementation_name", "platform_python_implementation", "extra",
+}
+
+// markerTokenRe matches one token of a marker.
+var markerTokenRe = regexp.MustCompile(`^\s*(===|==|!=|~=|<=|>=|<|>|\(|\)|'[^']*'|"[^"]*"|[A-Za-z_][A-Za-z0-9_.]*)`)
+
+// parseMarker parses a PEP 508 marker expression.
+func parseMarker(marker string) (*markerNode, error) {
+	var tokens []string
+	for rest := marker; strings.TrimSpace(rest) != ""; {
+		m := markerTokenRe.FindStringSubmatch(rest)
+		if m == nil {
+			return nil, fmt.Errorf("invalid marker %q", marker)
+		}
+		tokens = append(tokens, m[1])
+		rest = rest[len(m[0]):]
+	}
+	p := &markerParser{tokens: tokens}
+	node, err := p.or()
+	if err == nil && p.pos < len(tokens) {
+		err = fmt.Errorf("unexpected %q", tokens[p.pos])
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid marker %q: %w", marker, err)
+	}
+	return node, nil
+}
+
+// markerParser is a recursive descent parser over marker tokens.
+type markerParser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *markerParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *markerParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *markerParser) or() (*markerNode, error) {
+	return p.binary("or", p.and)
+}
+
+func (p *markerParser) and() (*markerNode, error) {
+	return p.binary("and", p.expr)
+}
+
+// binary parses operands joined by a left-associative operator.
+func (p *markerParser) binary(op string, operand func() (*markerNode, error)) (*markerNode, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == op {
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &markerNode{op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *markerParser) expr() (*markerNode, error) {
+	if p.peek() == "(" {
+		p.next()
+		node, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.next() != ")" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return node, nil
+	}
+	lhs, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	op := p.next()
+	switch op {
+	case "not":
+		if p.next() != "in" {
+			return nil, fmt.Errorf("expected \"in\" after \"not\"")
+		}
+		op = "not in"
+	case "in", "===", "==", "!=", "~=", "<=", ">=", "<", ">":
+	default:
+		return nil, fmt.Errorf("expected an operator, got %q", op)
+	}
+	rhs, err := p.operand()
+	if err != nil {
+		return nil, err
+	}
+	return &markerNode{op: op, lhs: lhs, rhs: rhs}, nil
+}
+
+func (p *markerParser) operand() (markerOperand, error) {
+	token := p.next()
+	switch {
+	case strings.HasPrefix(token, "'") || strings.HasPrefix(token, `"`):
+		return markerOperand{literal: token[1 : len(token)-1]}, nil
+	case token == "":
+		return markerOperand{}, fmt.Errorf("unexpected end")
+	case markerIdentRe.MatchString(token):
+		return markerOperand{variable: token}, nil
+	}
+	return markerOperand{}, fmt.Errorf("expected a string or a marker variable, got %q", token)
+}
+
+// markerIdentRe matches a marker variable name.
+var markerIdentRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_.]*$`)
+
+// unknown reports whether the operand is a variable the evaluator does not know.
+func (o markerOperand) unknown() bool {
+	return o.variable != "" && !slices.Contains(markerVariables, o.variable)
+}
+
+// eval evaluates the marker on a system for one requested extra ("" for none).
+func (n *markerNode) eval(system, python, extra string) (markerValue, error) {
+	switch n.op {
+	case "and", "or":
+		left, err := n.left.eval(system, python, extra)
+		if err != nil {
+			return markerFalse, err
+		}
+		right, err := n.right.eval(system, python, extra)
+		if err != nil {
+			return markerFalse, err
+		}
+		if n.op == "and" {
+			return markerAnd(left, right), nil
+		}
+		return markerOr(left, right), nil
+	}
+
+	// Variables the evaluator does not know (e.g. platform_release) are assumed
+	// to satisfy the comparison, so the dependency is kept rather than dropped
+	if n.lhs.unknown() || n.rhs.unknown() {
+		return markerTrue, nil
+	}
+	lhs, lhsNix := n.lhs.value(system, python, extra, n.rhs.literal)
+	rhs, rhsNix := n.rhs.value(system, python, extra, n.lhs.literal)
+	if lhsNix != "" || rhsNix != "" {
+		return pythonVersionCondition(n.op, lhs, lhsNix, rhs, rhsNix), nil
+	}
+	if n.lhs.variable == "extra" || n.rhs.variable == "extra" {
+		lhs, rhs = normalizeName(lhs), normalizeName(rhs)
+	}
+	return markerValue{value: compareMarker(n.op, lhs, rhs)}, nil
+}
+
+// value returns the value of an operand compared with other (the literal on
+// the other side, if any). The version of the unversioned python3 is only
+// known to Nix, so it is returned as a Nix expression; so is the full version
+// of a versioned interpreter when other is a version of the same minor
+// release, since the patch version decides the comparison.
+func (o markerOperand) value(system, python, extra, other string) (value, nix string) {
+	switch o.variable {
+	case "":
+		return o.literal, ""
+	case "python_version", "python_full_version":
+		if minor := pythonMinor(python); minor >= 0 {
+			version := fmt.Sprintf("3.%d", minor)
+			sameMinor := other == version || strings.HasPrefix(other, version+".") && !strings.HasSuffix(other, ".*")
+			if o.variable == "python_version" || !sameMinor {
+				return version, ""
+			}
+		}
+		if o.variable == "python_full_version" {
+			return "", "ps.python.version"
+		}
+		return "", "ps.python.pythonVersion"
+	case "os_name":
+		return "posix", ""
+	case "implementation_name":
+		return "cpython", ""
+	case "platform_python_implementation":
+		return "CPython", ""
+	case "extra":
+		return extra, ""
+	}
+	return systemMarkers[system][o.variable], ""
+}
+
+// compareMarker compares two marker values: as PEP 440 versions when the
+// right one is a version specifier, as strings otherwise.
+func compareMarker(op, lhs, rhs string) bool {
+	switch op {
+	case "in":
+		return strings.Contains(rhs, lhs)
+	case "not in":
+		return !strings.Contains(rhs, lhs)
+	}
+	if v, err := parseVersion(lhs); err == nil {
+		if clauses, err := parseSpecifierSet(op + rhs); err == nil {
+			return clauses[0].matches(lhs, v)
+		}
+	}
+	switch op {
+	case "==", "===":
+		return lhs == rhs
+	case "!=":
+		return lhs != rhs
+	}
+	return false
+}
+
+// pythonVersionCondition turns a comparison with the version of the
+// unversioned python3 into a Nix condition. Comparisons Nix cannot express
+// with builtins.compareVersions (~=, wildcards, in) are assumed to hold.
+func pythonVersionCondition(op, lhs, lhsNix, rhs, rhsNix string) markerValue {
+	version, literal := lhsNix, rhs
+	if version == "" {
+		// "3.11" > python_version is python_version < "3.11"
+		version, literal = rhsNix, lhs
+		op = map[string]string{"<": ">", "<=": ">=", ">": "<", ">=": "<=", "==": "==", "!=": "!="}[op]
+	}
+	switch op {
+	case "<", "<=", ">", ">=", "==", "!=":
+		if !strings.HasSuffix(literal, ".*") {
+			return markerValue{nix: fmt.Sprintf("builtins.compareVersions %s %q %s 0", version, literal, op)}
+		}
+	}
+	return markerTrue
+}
@@ -0,0 +1,85 @@
+package nix
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMarkerCondition(t *testing.T) {
+	tests := []struct {
+		marker    string
+		python    string
+		extras    []string
+		condition string
+		ok        bool
+	}{
+		{`sys_platform == "win32"`, "python312", nil, "", false},
+		{`sys_platform == "linux"`, "python312", nil, "pkgs.stdenv.isLinux", true},
+		{`sys_platform == "darwin" and platform_machine == "arm64"`, "python312", nil, `system == "aarch64-darwin"`, true},
+		// and binds tighter than or
+		{`sys_platform == "darwin" or sys_platform == "linux" and platform_machine == "aarch64"`, "python312", nil,
+			`builtins.elem system [ "aarch64-linux" "x86_64-darwin" "aarch64-darwin" ]`, true},
+		{`(sys_platform == "darwin" or sys_platform == "linux") and platform_machine == "aarch64"`, "python312", nil,
+			`system == "aarch64-linux"`, true},
+		// reversed operands
+		{`"3.11" > python_version`, "python312", nil, "", false},
+		{`"3.11" > python_version`, "python310", nil, "", true},
+		{`"3.11" > python_version`, "python3", nil, `builtins.compareVersions ps.python.pythonVersion "3.11" < 0`, true},
+		// the unversioned python3 leaves the version to Nix
+		{`python_version >= "3.11"`, "python3", nil, `builtins.compareVersions ps.python.pythonVersion "3.11" >= 0`, true},
+		{`python_version >= "3.11" and sys_platform == "linux"`, "python3", nil,
+			`pkgs.stdenv.isLinux && builtins.compareVersions ps.python.pythonVersion "3.11" >= 0`, true},
+		// the patch version is only known to Nix
+		{`python_full_version >= "3.11.4"`, "python311", nil, `builtins.compareVersions ps.python.version "3.11.4" >= 0`, true},
+		{`python_full_version >= "3.11.4"`, "python312", nil, "", true},
+		{`python_full_version >= "3.11.4"`, "python310", nil, "", false},
+		{`python_full_version == "3.12.*"`, "python312", nil, "", true},
+		// extras
+		{`extra == "socks"`, "python312", []string{"socks"}, "", true},
+		{`extra == "socks"`, "python312", nil, "", false},
+		{`extra == "Socks_Proxy"`, "python312", []string{"socks-proxy"}, "", true},
+		// unknown variables are assumed to hold
+		{`platform_release >= "5.0"`, "python312", nil, "", true},
+		{`extra == "dev" and platform_release >= "5.0"`, "python312", nil, "", false},
+	}
+	for _, tt := range tests {
+		condition, ok, err := markerCondition(tt.marker, markerEnv{python: tt.python, extras: tt.extras})
+		if err != nil {
+			t.Errorf("%s (%s): %v", tt.marker, tt.python, err)
+			continue
+		}
+		if condition != tt.condition || ok != tt.ok {
+			t.Errorf("%s (%s, extras %v) = %q, %v; want %q, %v", tt.marker, tt.python, tt.extras, condition, ok, tt.condition, tt.ok)
+		}
+	}
+}
+
+func TestMarkerConditionInvalid(t *testing.T) {
+	for _, marker := range []string{`sys_platform ==`, `sys_platform "linux"`, `(sys_platform == "linux"`} {
+		if _, _, err := markerCondition(marker, markerEnv{python: "python312"}); err == nil {
+			t.Errorf("%s: no error", marker)
+		}
+	}
+}
+
+func TestParseRuntimeDeps(t *testing.T) {
+	deps, conditions := parseRuntimeDeps([]string{
+		`idna`,
+		`pywin32; sys_platform == "win32"`,
+		`uvloop; sys_platform == "linux"`,
+		`distro; platform_release >= "5.0"`,
+		`broken; sys_platform ==`,
+		`pytest; extra == "test"`,
+	}, markerEnv{python: "python312"})
+	var names []string
+	for _, dep := range deps {
+		names = append(names, dep.Name)
+	}
+	want := []string{"idna", "uvloop", "distro", "broken"}
+	if fmt.Sprint(names) != fmt.Sprint(want) {
+		t.Errorf("deps = %v, want %v", names, want)
+	}
+	if len(conditions) != 1 || conditions["uvloop"] != "pkgs.stdenv.isLinux" {
+		t.Errorf("conditions = %v", conditions)
+	}
+}
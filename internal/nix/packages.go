@@ -7,21 +7,75 @@ import (
 	"github.com/mmxgn/manos-nix-template-builder/internal/models"
 )
 
-// NixpkgsChannels lists the available nixpkgs channels with their Python version support.
-var NixpkgsChannels = []models.NixpkgsChannel{
+// Names of features that change the shell itself rather than adding packages.
+const (
+	FeatureCUDA     = "CUDA Support"
+	FeatureROCm     = "ROCm Support"
+	FeatureFHS      = "FHS Environment"
+	FeatureVenv     = "Nix + venv (uv/pip)"
+	FeatureUv2nix   = "uv2nix (uv.lock workspace)"
+	FeatureChecks   = "Flake checks + formatter"
+	FeatureImage    = "OCI image (dockerImage)"
+	FeatureGitHooks = "Git hooks (git-hooks.nix)"
+)
+
+// Keys of feature parameters stored in UserConfig.FeatureParams.
+const (
+	ParamCUDAPackages     = "cudaPackages"     // versioned CUDA set; the bare name means channel default
+	ParamCUDACapabilities = "cudaCapabilities" // GPU compute capability; "all" leaves nixpkgs' default
+	ParamCUDABuild        = "cudaBuild"        // "source" (…WithCuda) or "prebuilt" (…-bin)
+	ParamNixFormatter     = "nixFormatter"     // formatter output for 'nix fmt'; "none" omits it
+	ParamImageBuilder     = "imageBuilder"     // "dockerTools" or "nix2container"
+	ParamImageEntrypoint  = "imageEntrypoint"  // command run by the container
+	ParamImagePorts       = "imagePorts"       // exposed ports, comma or space separated
+)
+
+// FallbackChannels lists the nixpkgs channels with their Python version
+// support when none have been discovered (see DiscoverChannels). Use Channels.
+var FallbackChannels = []models.NixpkgsChannel{
 	{
 		Name:      "nixos-unstable (recommended)",
 		FlakeURL:  "github:NixOS/nixpkgs/nixos-unstable",
 		IsDefault: true,
 		SupportedPythonVersions: []string{
-			"python3", "python39", "python310", "python311", "python312",
+			"python3", "python310", "python311", "python312", "python313",
+		},
+		CUDAPackageSets: []string{"cudaPackages_12_6", "cudaPackages_12_8"},
+		PackageIssues: []models.PackageIssue{
+			{Attr: "ecdsa", Version: "0.19.1", Reason: "CVE-2024-23342 (Minerva timing attack on P-256)"},
 		},
 	},
 	{
-		Name:     "nixos-24.11 (stable)",
+		Name:     "nixos-25.11 (stable)",
+		FlakeURL: "github:NixOS/nixpkgs/nixos-25.11",
+		SupportedPythonVersions: []string{
+			"python3", "python310", "python311", "python312", "python313",
+		},
+		CUDAPackageSets: []string{"cudaPackages_12_6", "cudaPackages_12_8"},
+		PackageIssues: []models.PackageIssue{
+			{Attr: "ecdsa", Version: "0.19.1", Reason: "CVE-2024-23342 (Minerva timing attack on P-256)"},
+		},
+	},
+	{
+		Name:     "nixos-25.05",
+		FlakeURL: "github:NixOS/nixpkgs/nixos-25.05",
+		SupportedPythonVersions: []string{
+			"python3", "python310", "python311", "python312", "python313",
+		},
+		CUDAPackageSets: []string{"cudaPackages_12_6", "cudaPackages_12_8"},
+		PackageIssues: []models.PackageIssue{
+			{Attr: "ecdsa", Version: "0.19.1", Reason: "CVE-2024-23342 (Minerva timing attack on P-256)"},
+		},
+	},
+	{
+		Name:     "nixos-24.11",
 		FlakeURL: "github:NixOS/nixpkgs/nixos-24.11",
 		SupportedPythonVersions: []string{
-			"python3", "python39", "python310", "python311", "python312",
+			"python3", "python39", "python310", "python311", "python312", "python313",
+		},
+		CUDAPackageSets: []string{"cudaPackages_11_8", "cudaPackages_12_1", "cudaPackages_12_4"},
+		PackageIssues: []models.PackageIssue{
+			{Attr: "ecdsa", Version: "0.19.0", Reason: "CVE-2024-23342 (Minerva timing attack on P-256)"},
 		},
 	},
 	{
@@ -30,6 +84,10 @@ var NixpkgsChannels = []models.NixpkgsChannel{
 		SupportedPythonVersions: []string{
 			"python3", "python310", "python311", "python312",
 		},
+		CUDAPackageSets: []string{"cudaPackages_11_8", "cudaPackages_12_1", "cudaPackages_12_3"},
+		PackageIssues: []models.PackageIssue{
+			{Attr: "ecdsa", Version: "0.19.0", Reason: "CVE-2024-23342 (Minerva timing attack on P-256)"},
+		},
 	},
 	{
 		Name:     "nixos-23.11",
@@ -37,6 +95,10 @@ var NixpkgsChannels = []models.NixpkgsChannel{
 		SupportedPythonVersions: []string{
 			"python3", "python310", "python311",
 		},
+		CUDAPackageSets: []string{"cudaPackages_11_8", "cudaPackages_12_0", "cudaPackages_12_2"},
+		PackageIssues: []models.PackageIssue{
+			{Attr: "tensorflow", Broken: true, Reason: "source build marked broken; tensorflow-bin still evaluates"},
+		},
 	},
 	{
 		Name:     "nixos-23.05",
@@ -44,6 +106,11 @@ var NixpkgsChannels = []models.NixpkgsChannel{
 		SupportedPythonVersions: []string{
 			"python3", "python310", "python311",
 		},
+		CUDAPackageSets: []string{"cudaPackages_11_7", "cudaPackages_11_8", "cudaPackages_12_0"},
+		PackageIssues: []models.PackageIssue{
+			{Attr: "tensorflow", Broken: true, Reason: "source build marked broken; tensorflow-bin still evaluates"},
+			{Attr: "jaxlib", Broken: true, Reason: "marked broken on Python 3.11"},
+		},
 	},
 }
 
@@ -52,13 +119,9 @@ var NixpkgsChannels = []models.NixpkgsChannel{
 var LanguageDefinitions = map[string]models.Language{
 	"python": {
 		Name: "Python",
-		AvailableVersions: []models.LanguageVersion{
-			{Name: "Python (latest)", NixAttr: "python3", IsDefault: true},
-			{Name: "Python 3.9", NixAttr: "python39", IsDefault: false},
-			{Name: "Python 3.10", NixAttr: "python310", IsDefault: false},
-			{Name: "Python 3.11", NixAttr: "python311", IsDefault: false},
-			{Name: "Python 3.12", NixAttr: "python312", IsDefault: false},
-		},
+		// The TUI offers PythonVersions(Channels()) instead, which includes
+		// discovered versions
+		AvailableVersions: PythonVersions(FallbackChannels),
 		AvailableTemplates: []models.LanguageTemplate{
 			{
 				Name:        "Data Science",
@@ -82,7 +145,7 @@ var LanguageDefinitions = map[string]models.Language{
 				Version:     "python3",
 				Packages:    []string{"torch", "numpy", "pandas", "matplotlib", "transformers", "diffusers", "sentencepiece", "triton"},
 				Tools:       []string{"git"},
-				Features:    []string{"CUDA Support"},
+				Features:    []string{FeatureCUDA},
 			},
 			{
 				Name:        "Minimal Python",
@@ -121,16 +184,22 @@ var LanguageDefinitions = map[string]models.Language{
 			{Name: "flask", NixAttr: "flask", Description: "Web framework", Category: "Web"},
 			{Name: "django", NixAttr: "django", Description: "Full-stack web framework", Category: "Web"},
 			// Machine Learning
-			{Name: "torch", NixAttr: "torch", Description: "PyTorch ML framework", Category: "Machine Learning"},
-			{Name: "torchaudio", NixAttr: "torchaudio", Description: "PyTorch audio processing", Category: "Machine Learning"},
-			{Name: "tensorflow", NixAttr: "tensorflow", Description: "TensorFlow ML framework", Category: "Machine Learning"},
+			{Name: "torch", NixAttr: "torch", Description: "PyTorch ML framework", Category: "Machine Learning",
+				Variants: map[string]string{"cuda": "torchWithCuda", "cuda-bin": "torch-bin", "rocm": "torchWithRocm"}},
+			{Name: "torchaudio", NixAttr: "torchaudio", Description: "PyTorch audio processing", Category: "Machine Learning",
+				Variants: map[string]string{"cuda-bin": "torchaudio-bin"}},
+			{Name: "tensorflow", NixAttr: "tensorflow", Description: "TensorFlow ML framework", Category: "Machine Learning",
+				Variants: map[string]string{"cuda": "tensorflowWithCuda", "cuda-bin": "tensorflow-bin"}},
+			{Name: "jax", NixAttr: "jax", Description: "Composable transformations of NumPy programs", Category: "Machine Learning"},
+			{Name: "jaxlib", NixAttr: "jaxlib", Description: "XLA backend for JAX", Category: "Machine Learning",
+				Variants: map[string]string{"cuda": "jaxlibWithCuda", "cuda-bin": "jaxlib-bin"}},
 			{Name: "transformers", NixAttr: "transformers", Description: "Hugging Face Transformers", Category: "Machine Learning"},
 			{Name: "diffusers", NixAttr: "diffusers", Description: "Hugging Face Diffusers", Category: "Machine Learning"},
 			{Name: "sentencepiece", NixAttr: "sentencepiece", Description: "Text tokenization library", Category: "Machine Learning"},
 			{Name: "triton", NixAttr: "triton", Description: "GPU kernel programming (OpenAI)", Category: "Machine Learning"},
 			{Name: "accelerate", NixAttr: "accelerate", Description: "Hugging Face Accelerate for distributed training", Category: "Machine Learning"},
 			{Name: "xformers", NixAttr: "xformers", Description: "Memory-efficient transformers with custom CUDA kernels", Category: "Machine Learning"},
-			{Name: "flash-attn", NixAttr: "flash-attn", Description: "Fast and memory-efficient exact attention", Category: "Machine Learning"},
+			{Name: "flash-attn", NixAttr: "flash-attn", Description: "Fast and memory-efficient exact attention", Category: "Machine Learning", AddedIn: "nixos-25.11"},
 			// Audio
 			{Name: "soundfile", NixAttr: "soundfile", Description: "Read/write audio files", Category: "Audio"},
 			{Name: "soxr", NixAttr: "soxr", Description: "High-quality audio resampling", Category: "Audio"},
@@ -139,17 +208,67 @@ var LanguageDefinitions = map[string]models.Language{
 		},
 		SpecialFeatures: []models.Feature{
 			{
-				Name:        "CUDA Support",
+				Name:        FeatureCUDA,
 				NixAttrs:    []string{"cudaPackages.cudatoolkit", "cudaPackages.cudnn"},
 				Description: "Enable NVIDIA CUDA for GPU acceleration",
 				Languages:   []string{"python"},
+				Params: []models.FeatureParam{
+					// Choices come from the selected channel, see ParamChoices
+					{Key: ParamCUDAPackages, Name: "CUDA version"},
+					{Key: ParamCUDACapabilities, Name: "GPU capability", Choices: []string{"all", "7.5", "8.0", "8.6", "8.9", "9.0"}},
+					{Key: ParamCUDABuild, Name: "Package builds", Choices: []string{"source", "prebuilt"}},
+				},
 			},
 			{
-				Name:        "FHS Environment",
+				Name:        FeatureROCm,
+				NixAttrs:    []string{"rocmPackages.clr", "rocmPackages.rocm-runtime", "rocmPackages.rocm-smi"},
+				Description: "Enable AMD ROCm for GPU acceleration (HIP runtime, rocm-smi)",
+				Languages:   []string{"python"},
+			},
+			{
+				Name:        FeatureFHS,
 				NixAttrs:    []string{},
 				Description: "Wrap shell in buildFHSEnv (standard Linux paths — useful for CUDA or foreign binaries)",
 				Languages:   []string{"python"},
 			},
+			{
+				Name:        FeatureVenv,
+				NixAttrs:    []string{"uv"},
+				Description: "Create/activate .venv on entry, run 'uv sync' when uv.lock exists, export LD_LIBRARY_PATH for wheels",
+				Languages:   []string{"python"},
+			},
+			{
+				Name:        FeatureUv2nix,
+				NixAttrs:    []string{"uv"},
+				Description: "Build the Python env from pyproject.toml + uv.lock with pyproject-nix/uv2nix (replaces the package list)",
+				Languages:   []string{"python"},
+			},
+			{
+				Name:        FeatureChecks,
+				NixAttrs:    []string{},
+				Description: "Run the selected pytest/ruff/mypy/black in 'nix flake check', set the 'nix fmt' formatter",
+				Languages:   []string{"python"},
+				Params: []models.FeatureParam{
+					{Key: ParamNixFormatter, Name: "Nix formatter", Choices: []string{"nixfmt", "alejandra", "nixpkgs-fmt", "none"}},
+				},
+			},
+			{
+				Name:        FeatureImage,
+				NixAttrs:    []string{},
+				Description: "Build packages.dockerImage with the Python env and project source ('nix build .#dockerImage')",
+				Languages:   []string{"python"},
+				Params: []models.FeatureParam{
+					{Key: ParamImageBuilder, Name: "Builder", Choices: []string{"dockerTools", "nix2container"}},
+					{Key: ParamImageEntrypoint, Name: "Entrypoint", FreeText: true, Default: "python main.py"},
+					{Key: ParamImagePorts, Name: "Exposed ports", FreeText: true, Default: "8000"},
+				},
+			},
+			{
+				Name:        FeatureGitHooks,
+				NixAttrs:    []string{},
+				Description: "Install pre-commit hooks for the selected ruff/black/mypy and the Nix formatter; adds checks.pre-commit-check",
+				Languages:   []string{"python"},
+			},
 		},
 		BuildSystem: "buildPythonPackage",
 	},
@@ -182,10 +301,97 @@ var CommonTools = []models.Package{
 	{Name: "gcc", NixAttr: "gcc", Description: "GNU Compiler Collection", Category: "Compilers"},
 	// Viewers & System
 	{Name: "bat", NixAttr: "bat", Description: "Cat with syntax highlighting", Category: "Viewers & System"},
-	{Name: "exa", NixAttr: "exa", Description: "Modern ls replacement", Category: "Viewers & System"},
+	{Name: "exa", NixAttr: "exa", Description: "Modern ls replacement", Category: "Viewers & System", RemovedIn: "nixos-23.11", RenamedTo: "eza"},
+	{Name: "eza", NixAttr: "eza", Description: "Maintained fork of exa", Category: "Viewers & System", AddedIn: "nixos-23.11"},
 	{Name: "htop", NixAttr: "htop", Description: "Interactive process viewer", Category: "Viewers & System"},
 }
 
+// NativeLibraryAttrs lists nixpkgs attrs that provide shared libraries commonly
+// loaded by manylinux wheels. In venv mode the selected ones are added to
+// LD_LIBRARY_PATH; zlib and libstdc++ are always included.
+var NativeLibraryAttrs = map[string]bool{
+	"zlib":                      true,
+	"stdenv.cc.cc.lib":          true,
+	"openssl":                   true,
+	"libGL":                     true,
+	"glib":                      true,
+	"libsndfile":                true,
+	"soxr":                      true,
+	"ffmpeg":                    true,
+	"cudaPackages.cudatoolkit":  true,
+	"cudaPackages.cudnn":        true,
+	"rocmPackages.clr":          true,
+	"rocmPackages.rocm-runtime": true,
+}
+
+// ShellHookSnippets is the catalog of reusable shellHook snippets offered in the
+// shellHook step. Lines are plain shell; they are escaped for Nix when rendered.
+var ShellHookSnippets = []models.ShellSnippet{
+	{
+		Name:        "Welcome message",
+		Description: "Print a line when the shell starts",
+		Lines:       []string{`echo "Development environment loaded."`},
+		IsDefault:   true,
+	},
+	{
+		Name:        "Print versions",
+		Description: "Show the Python and pip versions on entry",
+		Lines: []string{
+			`python --version`,
+			`python -m pip --version 2>/dev/null || true`,
+		},
+	},
+	{
+		Name:        "Install pre-commit hooks",
+		Description: "Run 'pre-commit install' when a .pre-commit-config.yaml exists",
+		Lines: []string{
+			`if [ -d .git ] && [ -f .pre-commit-config.yaml ]; then`,
+			`  pre-commit install --install-hooks > /dev/null`,
+			`fi`,
+		},
+	},
+	{
+		Name:        "Create .venv",
+		Description: "Create and activate a local virtualenv on top of the Nix Python",
+		Lines: []string{
+			`if [ ! -d .venv ]; then`,
+			`  python -m venv --system-site-packages .venv`,
+			`fi`,
+			`source .venv/bin/activate`,
+		},
+	},
+	{
+		Name:        "Add src/ to PYTHONPATH",
+		Description: "Make a src-layout project importable without installing it",
+		Lines:       []string{`export PYTHONPATH="$PWD/src${PYTHONPATH:+:$PYTHONPATH}"`},
+	},
+	{
+		Name:        "Load .env",
+		Description: "Export variables from a .env file if present",
+		Lines: []string{
+			`if [ -f .env ]; then`,
+			`  set -a; . ./.env; set +a`,
+			`fi`,
+		},
+	},
+	{
+		Name:        "Unset SOURCE_DATE_EPOCH",
+		Description: "Allow pip to build wheels with current timestamps",
+		Lines:       []string{`unset SOURCE_DATE_EPOCH`},
+	},
+}
+
+// DefaultShellHook returns the shellHook lines of all default snippets.
+func DefaultShellHook() []string {
+	var lines []string
+	for _, snippet := range ShellHookSnippets {
+		if snippet.IsDefault {
+			lines = append(lines, snippet.Lines...)
+		}
+	}
+	return lines
+}
+
 // GetLanguageNames returns all available language names
 func GetLanguageNames() []string {
 	names := make([]string, 0, len(LanguageDefinitions))
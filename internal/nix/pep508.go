@@ -0,0 +1,280 @@
+package nix
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Requirement is a PEP 508 dependency specifier such as "pydantic[email]>=2,<3".
+type Requirement struct {
+	Name      string   // project name as written
+	Extras    []string // requested extras, normalized (PEP 685)
+	Specifier string   // version specifier, e.g. ">=2,<3"; empty for any version
+	Marker    string   // environment marker after ";", unevaluated
+}
+
+// requirementRe splits a requirement into name, extras, specifier and marker.
+var requirementRe = regexp.MustCompile(`^\s*([A-Za-z0-9](?:[A-Za-z0-9._-]*[A-Za-z0-9])?)\s*(?:\[([^\]]*)\])?\s*([^;]*?)\s*(?:;\s*(.*?))?\s*$`)
+
+// ParseRequirement parses a PEP 508 requirement. Direct references
+// ("name @ https://…") are not supported.
+func ParseRequirement(s string) (Requirement, error) {
+	m := requirementRe.FindStringSubmatch(s)
+	if m == nil {
+		return Requirement{}, fmt.Errorf("invalid requirement %q", s)
+	}
+	req := Requirement{Name: m[1], Marker: m[4]}
+	if strings.HasPrefix(m[3], "@") {
+		return Requirement{}, fmt.Errorf("direct references are not supported: %q", s)
+	}
+	for _, extra := range strings.Split(m[2], ",") {
+		if extra = strings.TrimSpace(extra); extra != "" {
+			req.Extras = append(req.Extras, normalizeName(extra))
+		}
+	}
+	spec := strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(m[3], "("), ")"))
+	if _, err := parseSpecifierSet(spec); err != nil {
+		return Requirement{}, err
+	}
+	req.Specifier = strings.ReplaceAll(spec, " ", "")
+	return req, nil
+}
+
+// String formats the requirement without its marker, e.g. "pydantic[email]>=2,<3".
+func (r Requirement) String() string {
+	s := r.Name
+	if len(r.Extras) > 0 {
+		s += "[" + strings.Join(r.Extras, ",") + "]"
+	}
+	return s + r.Specifier
+}
+
+// normalizeName normalizes a project name (PEP 503) or an extra name (PEP 685).
+func normalizeName(name string) string {
+	return strings.ToLower(nameSeparatorRe.ReplaceAllString(name, "-"))
+}
+
+var nameSeparatorRe = regexp.MustCompile(`[-_.]+`)
+
+// pep440Version is a parsed PEP 440 version.
+type pep440Version struct {
+	epoch   int
+	release []int
+	preRank int // -1 dev-only release (sorts before its pre-releases), 0 a, 1 b, 2 rc, 3 none
+	pre     int
+	post    int // -1 without post segment
+	dev     int // math.MaxInt without dev segment
+	local   string
+}
+
+var versionRe = regexp.MustCompile(`(?i)^v?(?:(\d+)!)?(\d+(?:\.\d+)*)` +
+	`(?:[-_.]?(a|b|c|rc|alpha|beta|pre|preview)[-_.]?(\d+)?)?` +
+	`(?:-(\d+)|[-_.]?(post|rev|r)[-_.]?(\d+)?)?` +
+	`(?:[-_.]?(dev)[-_.]?(\d+)?)?` +
+	`(?:\+([a-z0-9]+(?:[-_.][a-z0-9]+)*))?$`)
+
+// parseVersion parses a PEP 440 version, accepting the normalizations it permits.
+func parseVersion(s string) (pep440Version, error) {
+	m := versionRe.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return pep440Version{}, fmt.Errorf("invalid version %q", s)
+	}
+	atoi := func(s string) int {
+		n, _ := strconv.Atoi(s)
+		return n
+	}
+	v := pep440Version{epoch: atoi(m[1]), preRank: 3, post: -1, dev: math.MaxInt, local: strings.ToLower(m[10])}
+	for _, part := range strings.Split(m[2], ".") {
+		v.release = append(v.release, atoi(part))
+	}
+	switch strings.ToLower(m[3]) {
+	case "a", "alpha":
+		v.preRank, v.pre = 0, atoi(m[4])
+	case "b", "beta":
+		v.preRank, v.pre = 1, atoi(m[4])
+	case "c", "rc", "pre", "preview":
+		v.preRank, v.pre = 2, atoi(m[4])
+	}
+	if m[5] != "" {
+		v.post = atoi(m[5])
+	} else if m[6] != "" {
+		v.post = atoi(m[7])
+	}
+	if m[8] != "" {
+		v.dev = atoi(m[9])
+		if v.preRank == 3 && v.post < 0 {
+			v.preRank = -1
+		}
+	}
+	return v, nil
+}
+
+// isPrerelease reports whether v is a pre- or development release.
+func (v pep440Version) isPrerelease() bool {
+	return v.preRank < 3 || v.dev != math.MaxInt
+}
+
+// compareRelease compares the release segments, padding the shorter with zeros.
+func compareRelease(a, b []int) int {
+	for i := range max(len(a), len(b)) {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if c := cmp.Compare(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareVersions orders versions as PEP 440 does.
+func compareVersions(a, b pep440Version) int {
+	return cmp.Or(
+		cmp.Compare(a.epoch, b.epoch),
+		compareRelease(a.release, b.release),
+		cmp.Compare(a.preRank, b.preRank),
+		cmp.Compare(a.pre, b.pre),
+		cmp.Compare(a.post, b.post),
+		cmp.Compare(a.dev, b.dev),
+		strings.Compare(a.local, b.local),
+	)
+}
+
+// versionClause is one comma-separated clause of a version specifier.
+type versionClause struct {
+	op       string // ==, !=, <, <=, >, >=, ~= or ===
+	raw      string // version as written, without a trailing ".*"
+	version  pep440Version
+	wildcard bool // "==1.4.*" or "!=1.4.*"
+}
+
+var clauseRe = regexp.MustCompile(`^(===|==|!=|~=|<=|>=|<|>)\s*([^\s<>=!~]\S*)$`)
+
+// parseSpecifierSet parses a PEP 440 version specifier such as ">=2,<3".
+func parseSpecifierSet(spec string) ([]versionClause, error) {
+	var clauses []versionClause
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		m := clauseRe.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return nil, fmt.Errorf("invalid version specifier %q", part)
+		}
+		clause := versionClause{op: m[1], raw: m[2]}
+		if clause.op == "===" {
+			clauses = append(clauses, clause)
+			continue
+		}
+		if strings.HasSuffix(clause.raw, ".*") {
+			if clause.op != "==" && clause.op != "!=" {
+				return nil, fmt.Errorf("%s does not allow a wildcard in %q", clause.op, part)
+			}
+			clause.raw = strings.TrimSuffix(clause.raw, ".*")
+			clause.wildcard = true
+		}
+		v, err := parseVersion(clause.raw)
+		if err != nil {
+			return nil, err
+		}
+		if clause.op == "~=" && len(v.release) < 2 {
+			return nil, fmt.Errorf("~= needs at least two release segments in %q", part)
+		}
+		clause.version = v
+		clauses = append(clauses, clause)
+	}
+	return clauses, nil
+}
+
+// matches reports whether v (written as raw) satisfies the clause.
+func (c versionClause) matches(raw string, v pep440Version) bool {
+	spec := c.version
+	switch c.op {
+	case "===":
+		return strings.EqualFold(raw, c.raw)
+	case "==", "!=":
+		var equal bool
+		if c.wildcard {
+			prefix := spec.release
+			equal = v.epoch == spec.epoch && compareRelease(padRelease(v.release, len(prefix))[:len(prefix)], prefix) == 0
+		} else {
+			if spec.local == "" {
+				v.local = ""
+			}
+			equal = compareVersions(v, spec) == 0
+		}
+		return equal == (c.op == "==")
+	case "~=":
+		prefix := versionClause{op: "==", version: pep440Version{epoch: spec.epoch, release: spec.release[:len(spec.release)-1]}, wildcard: true}
+		return compareVersions(v, spec) >= 0 && prefix.matches(raw, v)
+	case "<=":
+		return compareVersions(v, spec) <= 0
+	case ">=":
+		return compareVersions(v, spec) >= 0
+	case "<":
+		// <V excludes pre-releases of V itself unless V is a pre-release
+		if !spec.isPrerelease() && v.isPrerelease() && compareRelease(v.release, spec.release) == 0 && v.epoch == spec.epoch {
+			return false
+		}
+		return compareVersions(v, spec) < 0
+	case ">":
+		// >V excludes post-releases and local versions of V unless V is a post-release
+		if spec.post < 0 && v.epoch == spec.epoch && compareRelease(v.release, spec.release) == 0 &&
+			v.preRank == spec.preRank && v.pre == spec.pre && (v.post >= 0 || v.local != "") {
+			return false
+		}
+		return compareVersions(v, spec) > 0
+	}
+	return false
+}
+
+// padRelease pads release with zeros to at least n segments.
+func padRelease(release []int, n int) []int {
+	if len(release) >= n {
+		return release
+	}
+	padded := make([]int, n)
+	copy(padded, release)
+	return padded
+}
+
+// newestMatching returns the newest of versions satisfying spec. Pre-releases
+// only match when spec names one or when no final release satisfies spec, as
+// PEP 440 recommends. ok is false when spec is invalid or nothing matches.
+func newestMatching(versions []string, spec string) (newest string, ok bool) {
+	clauses, err := parseSpecifierSet(spec)
+	if err != nil {
+		return "", false
+	}
+	allowPre := slices.ContainsFunc(clauses, func(c versionClause) bool { return c.op != "===" && c.version.isPrerelease() })
+	var best, bestPre pep440Version
+	var bestRaw, bestPreRaw string
+	for _, raw := range versions {
+		v, err := parseVersion(raw)
+		if err != nil {
+			continue
+		}
+		if !slices.ContainsFunc(clauses, func(c versionClause) bool { return !c.matches(raw, v) }) {
+			if v.isPrerelease() && !allowPre {
+				if bestPreRaw == "" || compareVersions(v, bestPre) > 0 {
+					bestPre, bestPreRaw = v, raw
+				}
+			} else if bestRaw == "" || compareVersions(v, best) > 0 {
+				best, bestRaw = v, raw
+			}
+		}
+	}
+	if bestRaw == "" {
+		bestRaw = bestPreRaw
+	}
+	return bestRaw, bestRaw != ""
+}
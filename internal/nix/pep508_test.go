@@ -0,0 +1,65 @@
+package nix
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseRequirement(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Requirement
+	}{
+		{"httpx", Requirement{Name: "httpx"}},
+		{"httpx==0.27.0", Requirement{Name: "httpx", Specifier: "==0.27.0"}},
+		{"pydantic[email] >=2, <3", Requirement{Name: "pydantic", Extras: []string{"email"}, Specifier: ">=2,<3"}},
+		{"Foo.Bar[Socks_Proxy, HTTP2]~=1.4", Requirement{Name: "Foo.Bar", Extras: []string{"socks-proxy", "http2"}, Specifier: "~=1.4"}},
+		{"requests (>=2.0)", Requirement{Name: "requests", Specifier: ">=2.0"}},
+		{`pywin32>=300; sys_platform == "win32"`, Requirement{Name: "pywin32", Specifier: ">=300", Marker: `sys_platform == "win32"`}},
+		{`tomli; python_version < "3.11"`, Requirement{Name: "tomli", Marker: `python_version < "3.11"`}},
+	}
+	for _, tt := range tests {
+		got, err := ParseRequirement(tt.in)
+		if err != nil {
+			t.Errorf("ParseRequirement(%q): %v", tt.in, err)
+			continue
+		}
+		if got.Name != tt.want.Name || !slices.Equal(got.Extras, tt.want.Extras) || got.Specifier != tt.want.Specifier || got.Marker != tt.want.Marker {
+			t.Errorf("ParseRequirement(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "foo @ https://example.com/foo.tar.gz", "foo>=>1", "-foo"} {
+		if _, err := ParseRequirement(in); err == nil {
+			t.Errorf("ParseRequirement(%q): no error", in)
+		}
+	}
+}
+
+func TestNewestMatching(t *testing.T) {
+	versions := []string{"1.0", "1.4.2", "1.5", "2.0rc1", "2.0", "2.1.dev3", "not-a-version"}
+	tests := []struct {
+		spec   string
+		newest string
+		ok     bool
+	}{
+		{"", "2.0", true},
+		{"<2", "1.5", true},
+		{"~=1.4", "1.5", true},
+		{"~=1.4.0", "1.4.2", true},
+		{"==1.*", "1.5", true},
+		{"!=2.0,>=1.5", "1.5", true},
+		{"==2.0rc1", "2.0rc1", true},
+		{">=2.0rc1", "2.1.dev3", true}, // a pre-release in the spec allows them
+		{">2.0", "2.1.dev3", true},     // pre-releases when no final release matches
+		{"===1.4.2", "1.4.2", true},
+		{">=3", "", false},
+		{">=>1", "", false},
+	}
+	for _, tt := range tests {
+		newest, ok := newestMatching(versions, tt.spec)
+		if newest != tt.newest || ok != tt.ok {
+			t.Errorf("newestMatching(%q) = %q, %v; want %q, %v", tt.spec, newest, ok, tt.newest, tt.ok)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package nix
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// NixpkgsRevision is a nixpkgs commit the flake input can be pinned to.
+type NixpkgsRevision struct {
+	Rev    string // full 40-character commit hash
+	Date   string // commit date (YYYY-MM-DD), empty when unknown
+	Source string // where the revision was found (e.g., "nixos-unstable head", "flake.lock")
+	Branch string // nixpkgs branch the revision was taken from, empty when unknown
+}
+
+// channelHistoryURL lists the past bumps of a channel branch, one
+// "<rev> <unix time>" line per bump, oldest first.
+const channelHistoryURL = "https://channels.nix.gsc.io/%s/history"
+
+const (
+	// recentBumps is how many past channel bumps are offered as pin candidates.
+	recentBumps = 10
+
+	// channelHistoryTimeout bounds the channel history download.
+	channelHistoryTimeout = 15 * time.Second
+)
+
+// FlakeMetadataOutput represents the JSON output of `nix flake metadata --json`
+type FlakeMetadataOutput struct {
+	Locked struct {
+		Rev          string `json:"rev"`
+		LastModified int64  `json:"lastModified"`
+	} `json:"locked"`
+}
+
+// flakeLock represents the parts of flake.lock needed to read a locked nixpkgs
+type flakeLock struct {
+	Nodes map[string]struct {
+		Locked struct {
+			Owner        string `json:"owner"`
+			Repo         string `json:"repo"`
+			Rev          string `json:"rev"`
+			LastModified int64  `json:"lastModified"`
+		} `json:"locked"`
+		Original struct {
+			Ref string `json:"ref"`
+		} `json:"original"`
+	} `json:"nodes"`
+}
+
+// revRe matches a full git commit hash.
+var revRe = regexp.MustCompile(`^[0-9a-f]{40}$`)
+
+// ValidRev reports whether rev is a full 40-character commit hash.
+func ValidRev(rev string) bool {
+	return revRe.MatchString(rev)
+}
+
+// PinnedURL replaces the branch of a github:NixOS/nixpkgs/<branch> URL with rev.
+func PinnedURL(channelURL, rev string) string {
+	if i := strings.LastIndex(channelURL, "/"); i > len("github:") {
+		return channelURL[:i+1] + rev
+	}
+	return "github:NixOS/nixpkgs/" + rev
+}
+
+// ChannelBranch returns the branch of a github:NixOS/nixpkgs/<branch> URL.
+func ChannelBranch(channelURL string) string {
+	return channelURL[strings.LastIndex(channelURL, "/")+1:]
+}
+
+// DiscoverRevisions collects pin candidates for a channel: the current channel
+// head from `nix flake metadata`, the recent bumps of the channel, nixpkgs
+// entries of the local registry, and the nixpkgs node of ./flake.lock. Sources
+// that fail (no nix, no network) are skipped.
+func DiscoverRevisions(ctx context.Context, channelURL, lockPath string) []NixpkgsRevision {
+	var revisions []NixpkgsRevision
+	seen := make(map[string]bool)
+	add := func(rev NixpkgsRevision) {
+		if ValidRev(rev.Rev) && !seen[rev.Rev] {
+			seen[rev.Rev] = true
+			revisions = append(revisions, rev)
+		}
+	}
+
+	branch := ChannelBranch(channelURL)
+	if rev, err := FetchRevision(ctx, channelURL); err == nil {
+		rev.Source = branch + " head"
+		rev.Branch = branch
+		add(rev)
+	}
+	for _, rev := range channelBumps(ctx, branch) {
+		add(rev)
+	}
+	for _, rev := range registryRevisions(ctx) {
+		add(rev)
+	}
+	if rev, ok := lockedRevision(lockPath); ok {
+		add(rev)
+	}
+	return revisions
+}
+
+// FetchRevision resolves a flake URL with `nix flake metadata` and returns the
+// locked revision and its date.
+func FetchRevision(ctx context.Context, flakeURL string) (NixpkgsRevision, error) {
+	cmd := exec.CommandContext(ctx, "nix", "flake", "metadata", "--json", flakeURL)
+	output, err := cmd.Output()
+	if err != nil {
+		return NixpkgsRevision{}, fmt.Errorf("failed to read flake metadata: %w", err)
+	}
+
+	var metadata FlakeMetadataOutput
+	if err := json.Unmarshal(output, &metadata); err != nil {
+		return NixpkgsRevision{}, fmt.Errorf("failed to parse flake metadata: %w", err)
+	}
+	return NixpkgsRevision{
+		Rev:  metadata.Locked.Rev,
+		Date: unixDate(metadata.Locked.LastModified),
+	}, nil
+}
+
+// channelBumps returns the most recent bumps of a channel branch, newest first.
+// It returns nil when the history cannot be downloaded.
+func channelBumps(ctx context.Context, branch string) []NixpkgsRevision {
+	ctx, cancel := context.WithTimeout(ctx, channelHistoryTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf(channelHistoryURL, branch), nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var bumps []NixpkgsRevision
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		rev := NixpkgsRevision{Rev: fields[0], Source: branch + " bump", Branch: branch}
+		if bumped, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			rev.Date = unixDate(bumped)
+		}
+		bumps = append(bumps, rev)
+	}
+	bumps = bumps[max(0, len(bumps)-recentBumps):]
+	slices.Reverse(bumps)
+	return bumps
+}
+
+// registryRevisions returns nixpkgs entries of `nix registry list` that are
+// pinned to a revision, e.g. the system registry entry on NixOS.
+func registryRevisions(ctx context.Context) []NixpkgsRevision {
+	output, err := exec.CommandContext(ctx, "nix", "registry", "list").Output()
+	if err != nil {
+		return nil
+	}
+
+	var revisions []NixpkgsRevision
+	for _, line := range strings.Split(string(output), "\n") {
+		// <scope> flake:nixpkgs <target>
+		fields := strings.Fields(line)
+		if len(fields) != 3 || fields[1] != "flake:nixpkgs" {
+			continue
+		}
+		target, query, _ := strings.Cut(fields[2], "?")
+		rev := NixpkgsRevision{Source: fields[0] + " registry"}
+		// github:NixOS/nixpkgs/<branch or rev>
+		if ref, ok := strings.CutPrefix(target, "github:NixOS/nixpkgs/"); ok {
+			if ValidRev(ref) {
+				rev.Rev = ref
+			} else {
+				rev.Branch = ref
+			}
+		}
+		if query != "" {
+			values, _ := url.ParseQuery(query)
+			if r := values.Get("rev"); r != "" {
+				rev.Rev = r
+			}
+			if modified, err := strconv.ParseInt(values.Get("lastModified"), 10, 64); err == nil {
+				rev.Date = unixDate(modified)
+			}
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions
+}
+
+// lockedRevision reads the nixpkgs node of a flake.lock.
+func lockedRevision(lockPath string) (NixpkgsRevision, bool) {
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return NixpkgsRevision{}, false
+	}
+	var lock flakeLock
+	if err := json.Unmarshal(data, &lock); err != nil {
+		return NixpkgsRevision{}, false
+	}
+	node, ok := lock.Nodes["nixpkgs"]
+	if !ok || node.Locked.Repo != "nixpkgs" {
+		return NixpkgsRevision{}, false
+	}
+	source := "flake.lock"
+	if node.Original.Ref != "" {
+		source = "flake.lock (" + node.Original.Ref + ")"
+	}
+	return NixpkgsRevision{
+		Rev:    node.Locked.Rev,
+		Date:   unixDate(node.Locked.LastModified),
+		Source: source,
+		Branch: node.Original.Ref,
+	}, true
+}
+
+// unixDate formats a Unix timestamp as YYYY-MM-DD (UTC); zero yields "".
+func unixDate(timestamp int64) string {
+	if timestamp == 0 {
+		return ""
+	}
+	return time.Unix(timestamp, 0).UTC().Format("2006-01-02")
+}
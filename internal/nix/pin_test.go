@@ -0,0 +1,70 @@
+package nix
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+func TestDiscoverRevisions(t *testing.T) {
+	// 12 bumps, oldest first, one day apart
+	var history strings.Builder
+	for i := range 12 {
+		fmt.Fprintf(&history, "%040x %d\n", i+1, 1700000000+i*86400)
+	}
+	fakeHTTP(t, map[string]string{"/nixos-unstable/history": history.String()})
+
+	lockPath := filepath.Join(t.TempDir(), "flake.lock")
+	lock := `{"nodes":{"nixpkgs":{"locked":{"owner":"NixOS","repo":"nixpkgs","rev":"` + strings.Repeat("a", 40) +
+		`","lastModified":1700000000},"original":{"ref":"nixos-24.05"}}}}`
+	if err := os.WriteFile(lockPath, []byte(lock), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Hide nix so the channel head and the registry are skipped
+	t.Setenv("PATH", t.TempDir())
+	revisions := DiscoverRevisions(context.Background(), "github:NixOS/nixpkgs/nixos-unstable", lockPath)
+	if len(revisions) != recentBumps+1 {
+		t.Fatalf("got %d revisions, want %d: %v", len(revisions), recentBumps+1, revisions)
+	}
+	newest := revisions[0]
+	if newest.Rev != fmt.Sprintf("%040x", 12) || newest.Date != "2023-11-25" || newest.Branch != "nixos-unstable" {
+		t.Errorf("newest bump = %+v", newest)
+	}
+	if oldest := revisions[recentBumps-1]; oldest.Rev != fmt.Sprintf("%040x", 3) {
+		t.Errorf("oldest bump = %+v, want the 10th most recent", oldest)
+	}
+	if locked := revisions[recentBumps]; locked.Source != "flake.lock (nixos-24.05)" || locked.Branch != "nixos-24.05" {
+		t.Errorf("flake.lock revision = %+v", locked)
+	}
+}
+
+func TestGenerateFlakePinComment(t *testing.T) {
+	tests := []struct {
+		branch, want string
+	}{
+		{"nixos-24.05", "# Pinned: nixos-24.05 revision from 2023-11-14"},
+		{"", "# Pinned: nixpkgs revision from 2023-11-14"},
+	}
+	for _, tt := range tests {
+		flake, err := GenerateFlake(models.UserConfig{
+			Language:         "python",
+			LanguageVersion:  "python312",
+			NixpkgsURL:       "github:NixOS/nixpkgs/nixos-unstable",
+			NixpkgsRev:       strings.Repeat("a", 40),
+			NixpkgsRevDate:   "2023-11-14",
+			NixpkgsRevBranch: tt.branch,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(flake, tt.want) {
+			t.Errorf("branch %q: missing %q:\n%s", tt.branch, tt.want, flake)
+		}
+	}
+}
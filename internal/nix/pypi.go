@@ -3,6 +3,7 @@ package nix
 import (
 	"archive/tar"
 	"compress/gzip"
+	"context"
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
@@ -10,63 +11,221 @@ import (
 	"io"
 	"net/http"
 	"regexp"
+	"slices"
 	"strings"
+	"sync"
+	"time"
 )
 
+// Timeouts of single PyPI requests. The sdist is only read up to its
+// pyproject.toml, but large sdists on slow networks still take a while.
+const (
+	pypiRequestTimeout = 15 * time.Second
+	sdistTimeout       = 45 * time.Second
+)
+
+// pypiWorkers bounds the number of concurrent PyPI lookups.
+const pypiWorkers = 4
+
+// PyPIProgress reports the state of one PyPI lookup while a flake is generated.
+type PyPIProgress struct {
+	Name     string // package name, or "attr==version" for a pinned override
+	Done     bool
+	Resolved bool   // found on PyPI; Done without Resolved means pkgs.lib.fakeHash is used
+	Version  string // resolved version
+}
+
+// pypiTask is one PyPI lookup; run stores its result and reports it.
+type pypiTask struct {
+	name string
+	run  func(ctx context.Context) PyPIProgress
+}
+
+// resolvePyPITasks runs tasks on a bounded worker pool. progress (may be nil)
+// first receives every task as pending, then each result as it completes, from
+// the worker goroutines. It returns ctx.Err() when ctx was cancelled.
+func resolvePyPITasks(ctx context.Context, tasks []pypiTask, progress func(PyPIProgress)) error {
+	report := func(p PyPIProgress) {
+		if progress != nil {
+			progress(p)
+		}
+	}
+	for _, task := range tasks {
+		report(PyPIProgress{Name: task.name})
+	}
+
+	jobs := make(chan pypiTask)
+	var wg sync.WaitGroup
+	for range min(pypiWorkers, len(tasks)) {
+		wg.Go(func() {
+			for task := range jobs {
+				result := task.run(ctx)
+				result.Name, result.Done = task.name, true
+				report(result)
+			}
+		})
+	}
+queue:
+	for _, task := range tasks {
+		select {
+		case jobs <- task:
+		case <-ctx.Done():
+			break queue
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return ctx.Err()
+}
+
 // PyPIPackageInfo holds resolved metadata for a single PyPI package.
 type PyPIPackageInfo struct {
-	Name        string   // package name as typed by the user
-	Version     string   // latest version resolved from PyPI (empty if lookup failed)
-	HashExpr    string   // Nix expression: quoted "sha256-..." or bare pkgs.lib.fakeHash
-	BuildDeps   []string // nixpkgs attrs for build-system, e.g. ["hatchling", "hatch-vcs"]
-	RuntimeDeps []string // nixpkgs attrs for propagatedBuildInputs (from requires_dist)
-	Resolved    bool     // true when version + hash were fetched successfully
-}
-
-// ResolvePyPIPackage fetches the latest version, SHA-256 hash, and build
-// dependencies for a package from the PyPI JSON API. On any failure it returns
-// a stub with pkgs.lib.fakeHash and setuptools.
-func ResolvePyPIPackage(name string) PyPIPackageInfo {
-	info, err := fetchPyPIInfo(name)
+	Name          string            // package name as typed by the user
+	Extras        []string          // requested extras, whose dependencies are in RuntimeDeps
+	Constraint    string            // version specifier as typed by the user, e.g. ">=2,<3"
+	Version       string            // newest version matching Constraint (empty if lookup failed)
+	HashExpr      string            // Nix expression: quoted "sha256-..." or bare pkgs.lib.fakeHash
+	Wheels        []PyPIWheel       // wheels to install instead of the sdist, when the release has none
+	BuildDeps     []string          // nixpkgs attrs for build-system, e.g. ["hatchling", "hatch-vcs"]
+	RuntimeDeps   []string          // attrs for propagatedBuildInputs (from requires_dist)
+	DepConditions map[string]string // Nix conditions of RuntimeDeps needed on some systems or Pythons only
+	Requires      []Requirement     // the requires_dist entries behind RuntimeDeps
+	RequiredBy    string            // name of the PyPI package that pulled it in; empty when selected
+	Cycles        []string          // attrs left out of RuntimeDeps since they depend on this package
+	Resolved      bool              // true when version + hash were fetched successfully
+}
+
+// ResolvePyPIPackage fetches the newest version matching a PEP 508
+// requirement (e.g. "pydantic[email]>=2,<3"), its SHA-256 hash, and build and
+// runtime dependencies from the PyPI JSON API. Releases without sdist install
+// from wheels for the interpreter of pythonAttr (see selectWheels). On any
+// failure (including cancellation of ctx) it returns a stub with
+// pkgs.lib.fakeHash and setuptools. Resolved packages are memoized.
+func ResolvePyPIPackage(ctx context.Context, requirement, pythonAttr string) PyPIPackageInfo {
+	key := [2]string{requirement, pythonAttr}
+	pypiCacheMu.Lock()
+	info, ok := pypiCache[key]
+	pypiCacheMu.Unlock()
+	if ok {
+		return info
+	}
+
+	req, err := ParseRequirement(requirement)
+	if err != nil {
+		req = Requirement{Name: requirement}
+	}
+	info, err = fetchPyPIInfo(ctx, req, pythonAttr)
 	if err != nil {
 		return PyPIPackageInfo{
-			Name:      name,
-			HashExpr:  "pkgs.lib.fakeHash",
-			BuildDeps: []string{"setuptools"},
-			Resolved:  false,
+			Name:       req.Name,
+			Extras:     req.Extras,
+			Constraint: req.Specifier,
+			HashExpr:   "pkgs.lib.fakeHash",
+			BuildDeps:  []string{"setuptools"},
+			Resolved:   false,
 		}
 	}
+	pypiCacheMu.Lock()
+	pypiCache[key] = info
+	pypiCacheMu.Unlock()
 	return info
 }
 
-func fetchPyPIInfo(name string) (PyPIPackageInfo, error) {
+// pypiCache memoizes ResolvePyPIPackage by requirement and Python attr, so the
+// dependency tree shown in the PyPI overlay is not fetched again for the flake.
+var (
+	pypiCacheMu sync.Mutex
+	pypiCache   = make(map[[2]string]PyPIPackageInfo)
+)
+
+// ResolvePyPISdistHash returns the Nix hash expression (quoted SRI string) of
+// the sdist of name at the given version.
+func ResolvePyPISdistHash(ctx context.Context, name, version string) (string, error) {
+	payload, err := fetchPyPIRelease(ctx, name, version)
+	if err != nil {
+		return "", err
+	}
+	for _, u := range payload.URLs {
+		if u.PackageType != "sdist" {
+			continue
+		}
+		sri, err := hexSHA256ToSRI(u.Digests.SHA256)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(`"sha256-%s"`, sri), nil
+	}
+	return "", fmt.Errorf("no sdist found for %s %s", name, version)
+}
+
+// pypiRelease is the subset of the PyPI JSON API response we use.
+type pypiRelease struct {
+	Info struct {
+		Name         string   `json:"name"`
+		Version      string   `json:"version"`
+		RequiresDist []string `json:"requires_dist"`
+	} `json:"info"`
+	URLs     []pypiFile            `json:"urls"`
+	Releases map[string][]pypiFile `json:"releases"` // only in the response for the latest release
+}
+
+// pypiFile is one distribution file of a release.
+type pypiFile struct {
+	Filename      string `json:"filename"`
+	PackageType   string `json:"packagetype"`
+	PythonVersion string `json:"python_version"`
+	URL           string `json:"url"`
+	Yanked        bool   `json:"yanked"`
+	Digests       struct {
+		SHA256 string `json:"sha256"`
+	} `json:"digests"`
+}
+
+// fetchPyPIRelease queries the PyPI JSON API for a release of name. An empty
+// version selects the latest release.
+func fetchPyPIRelease(ctx context.Context, name, version string) (pypiRelease, error) {
 	url := fmt.Sprintf("https://pypi.org/pypi/%s/json", name)
-	resp, err := http.Get(url) //nolint:noctx
+	if version != "" {
+		url = fmt.Sprintf("https://pypi.org/pypi/%s/%s/json", name, version)
+	}
+	ctx, cancel := context.WithTimeout(ctx, pypiRequestTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return pypiRelease{}, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
-		return PyPIPackageInfo{}, fmt.Errorf("http: %w", err)
+		return pypiRelease{}, fmt.Errorf("http: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return PyPIPackageInfo{}, fmt.Errorf("pypi returned %d for %q", resp.StatusCode, name)
-	}
-
-	var payload struct {
-		Info struct {
-			Name         string   `json:"name"`
-			Version      string   `json:"version"`
-			RequiresDist []string `json:"requires_dist"`
-		} `json:"info"`
-		URLs []struct {
-			PackageType string `json:"packagetype"`
-			URL         string `json:"url"`
-			Digests     struct {
-				SHA256 string `json:"sha256"`
-			} `json:"digests"`
-		} `json:"urls"`
+		return pypiRelease{}, fmt.Errorf("pypi returned %d for %q", resp.StatusCode, name)
 	}
+
+	var payload pypiRelease
 	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
-		return PyPIPackageInfo{}, fmt.Errorf("json: %w", err)
+		return pypiRelease{}, fmt.Errorf("json: %w", err)
+	}
+	return payload, nil
+}
+
+func fetchPyPIInfo(ctx context.Context, req Requirement, pythonAttr string) (PyPIPackageInfo, error) {
+	payload, err := fetchPyPIRelease(ctx, req.Name, "")
+	if err != nil {
+		return PyPIPackageInfo{}, err
+	}
+	if req.Specifier != "" {
+		version, ok := newestRelease(payload.Releases, req.Specifier)
+		if !ok {
+			return PyPIPackageInfo{}, fmt.Errorf("no release of %s matches %s", req.Name, req.Specifier)
+		}
+		if version != payload.Info.Version {
+			if payload, err = fetchPyPIRelease(ctx, req.Name, version); err != nil {
+				return PyPIPackageInfo{}, err
+			}
+		}
 	}
 
 	for _, u := range payload.URLs {
@@ -79,34 +238,74 @@ func fetchPyPIInfo(name string) (PyPIPackageInfo, error) {
 			return PyPIPackageInfo{}, err
 		}
 
-		buildDeps := detectBuildDeps(u.URL)
-		runtimeDeps := parseRuntimeDeps(payload.Info.RequiresDist)
+		buildDeps := detectBuildDeps(ctx, u.URL)
+		requires, conditions := parseRuntimeDeps(payload.Info.RequiresDist, markerEnv{python: pythonAttr, extras: req.Extras})
 
 		return PyPIPackageInfo{
-			Name:        payload.Info.Name,
-			Version:     payload.Info.Version,
-			HashExpr:    fmt.Sprintf(`"sha256-%s"`, sri),
-			BuildDeps:   buildDeps,
-			RuntimeDeps: runtimeDeps,
-			Resolved:    true,
+			Name:          payload.Info.Name,
+			Extras:        req.Extras,
+			Constraint:    req.Specifier,
+			Version:       payload.Info.Version,
+			HashExpr:      fmt.Sprintf(`"sha256-%s"`, sri),
+			BuildDeps:     buildDeps,
+			RuntimeDeps:   requirementAttrs(requires),
+			DepConditions: conditions,
+			Requires:      requires,
+			Resolved:      true,
 		}, nil
 	}
 
-	return PyPIPackageInfo{}, fmt.Errorf("no sdist found for %q", name)
+	requires, conditions := parseRuntimeDeps(payload.Info.RequiresDist, markerEnv{python: pythonAttr, extras: req.Extras})
+	wheels, err := selectWheels(payload.URLs, pythonAttr)
+	if err != nil {
+		return PyPIPackageInfo{}, err
+	}
+	if len(wheels) == 0 {
+		return PyPIPackageInfo{}, fmt.Errorf("no sdist or compatible wheel found for %q", req.Name)
+	}
+	return PyPIPackageInfo{
+		Name:          payload.Info.Name,
+		Extras:        req.Extras,
+		Constraint:    req.Specifier,
+		Version:       payload.Info.Version,
+		Wheels:        wheels,
+		RuntimeDeps:   requirementAttrs(requires),
+		DepConditions: conditions,
+		Requires:      requires,
+		Resolved:      true,
+	}, nil
+}
+
+// newestRelease picks the newest release matching spec that has files which
+// are not yanked.
+func newestRelease(releases map[string][]pypiFile, spec string) (string, bool) {
+	var versions []string
+	for version, files := range releases {
+		if slices.ContainsFunc(files, func(f pypiFile) bool { return !f.Yanked }) {
+			versions = append(versions, version)
+		}
+	}
+	return newestMatching(versions, spec)
 }
 
 // detectBuildDeps downloads the sdist, reads pyproject.toml, and returns the
 // list of nixpkgs Python package attrs needed for build-system.
-func detectBuildDeps(sdistURL string) []string {
-	deps, err := fetchBuildDeps(sdistURL)
+func detectBuildDeps(ctx context.Context, sdistURL string) []string {
+	deps, err := fetchBuildDeps(ctx, sdistURL)
 	if err != nil || len(deps) == 0 {
 		return []string{"setuptools"}
 	}
 	return deps
 }
 
-func fetchBuildDeps(sdistURL string) ([]string, error) {
-	resp, err := http.Get(sdistURL) //nolint:noctx
+func fetchBuildDeps(ctx context.Context, sdistURL string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, sdistTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, sdistURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -170,33 +369,66 @@ func parseBuildDeps(toml string) []string {
 	return deps
 }
 
-// pkgSpecRe extracts the bare package name from a PEP 508 dependency specifier,
-// e.g. "httpx>=0.27" → "httpx", "pydantic[email]>=2" → "pydantic".
-var pkgSpecNameRe = regexp.MustCompile(`^([A-Za-z0-9_.-]+)`)
-
-// parseRuntimeDeps converts a requires_dist list (PEP 508 specifiers) to a
-// list of nixpkgs Python package attrs. Entries with environment markers
-// ("; sys_platform ==…" etc.) are skipped — they are optional/platform-specific.
-func parseRuntimeDeps(requiresDist []string) []string {
-	var deps []string
+// parseRuntimeDeps parses a requires_dist list (PEP 508 specifiers), one
+// requirement per project. Environment markers are evaluated for the
+// interpreter and requested extras of env on every default system (see
+// markerCondition): dependencies whose marker never holds, or cannot be
+// parsed, are skipped, and those needed only on some systems or Python
+// versions get their Nix condition in conditions, keyed by attr.
+func parseRuntimeDeps(requiresDist []string, env markerEnv) (deps []Requirement, conditions map[string]string) {
+	conditions = make(map[string]string)
 	for _, spec := range requiresDist {
-		// Skip conditional/optional dependencies entirely.
-		if strings.Contains(spec, ";") {
+		req, err := ParseRequirement(spec)
+		if err != nil {
 			continue
 		}
-		name := pkgSpecNameRe.FindString(spec)
-		if name == "" {
+		var condition string
+		if req.Marker != "" {
+			// Markers that do not parse keep the dependency everywhere rather
+			// than dropping it
+			var ok bool
+			condition, ok, err = markerCondition(req.Marker, env)
+			if err != nil {
+				condition = ""
+			} else if !ok {
+				continue
+			}
+		}
+		attr := pypiNameToNixAttr(req.Name)
+		idx := slices.IndexFunc(deps, func(dep Requirement) bool { return pypiNameToNixAttr(dep.Name) == attr })
+		if idx < 0 {
+			deps = append(deps, req)
+			if condition != "" {
+				conditions[attr] = condition
+			}
 			continue
 		}
-		deps = append(deps, pypiNameToNixAttr(name))
+		// Listed again under another marker, e.g. with a version bound per Python version
+		if previous, ok := conditions[attr]; ok {
+			if condition == "" {
+				delete(conditions, attr)
+			} else if previous != condition {
+				conditions[attr] = fmt.Sprintf("(%s) || (%s)", previous, condition)
+			}
+		}
 	}
-	return deps
+	return deps, conditions
+}
+
+// requirementAttrs returns the Python package attrs of requirements.
+func requirementAttrs(requirements []Requirement) []string {
+	attrs := make([]string, len(requirements))
+	for i, req := range requirements {
+		attrs[i] = pypiNameToNixAttr(req.Name)
+	}
+	return attrs
 }
 
 // pypiNameToNixAttr converts a PyPI package name to its nixpkgs Python attr.
-// Most names match after lowercasing and replacing underscores with hyphens.
+// Most names match the normalized name (lowercase, runs of "-", "_" and "."
+// replaced with a hyphen).
 func pypiNameToNixAttr(name string) string {
-	normalized := strings.ToLower(strings.ReplaceAll(name, "_", "-"))
+	normalized := normalizeName(name)
 	overrides := map[string]string{
 		"scikit-build-core": "scikit-build-core",
 		"setuptools-scm":    "setuptools-scm",
@@ -0,0 +1,165 @@
+package nix
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// Attr returns the name the package is bound to in the flake, which is also
+// its nixpkgs attr when nixpkgs has it.
+func (p PyPIPackageInfo) Attr() string {
+	return pypiNameToNixAttr(p.Name)
+}
+
+// ResolvePyPIPackages resolves PyPI requirements like ResolvePyPIPackage and,
+// recursively, their runtime dependencies missing from the channel of config.
+// The result is ordered dependencies first (see orderPyPIPackages). progress
+// is called as for resolvePyPITasks; the error is ctx.Err().
+func ResolvePyPIPackages(ctx context.Context, requirements []string, config models.UserConfig, progress func(PyPIProgress)) ([]PyPIPackageInfo, error) {
+	packages := make([]PyPIPackageInfo, len(requirements))
+	if err := resolvePyPITasks(ctx, pypiPackageTasks(requirements, config.LanguageVersion, packages), progress); err != nil {
+		return nil, err
+	}
+	return resolvePyPIDependencies(ctx, packages, LoadIndex(config.NixpkgsURL), config, progress)
+}
+
+// pypiPackageTasks returns the tasks resolving requirements into packages.
+func pypiPackageTasks(requirements []string, pythonAttr string, packages []PyPIPackageInfo) []pypiTask {
+	tasks := make([]pypiTask, len(requirements))
+	for i, requirement := range requirements {
+		tasks[i] = pypiTask{name: requirement, run: func(ctx context.Context) PyPIProgress {
+			packages[i] = ResolvePyPIPackage(ctx, requirement, pythonAttr)
+			return PyPIProgress{Resolved: packages[i].Resolved, Version: packages[i].Version}
+		}}
+	}
+	return tasks
+}
+
+// resolvePyPIDependencies resolves, round by round, the runtime dependencies
+// of packages that the channel lacks (see nixpkgsHasAttrs), and returns them
+// along with packages in dependency order.
+func resolvePyPIDependencies(ctx context.Context, packages []PyPIPackageInfo, index *PackageIndex, config models.UserConfig, progress func(PyPIProgress)) ([]PyPIPackageInfo, error) {
+	bound := make(map[string]bool)
+	for _, pkg := range packages {
+		bound[pkg.Attr()] = true
+	}
+	pending := packages
+	for len(pending) > 0 {
+		var candidates []Requirement
+		var attrs, candidatesBy []string
+		for _, pkg := range pending {
+			for _, req := range pkg.Requires {
+				attr := pypiNameToNixAttr(req.Name)
+				if bound[attr] {
+					continue
+				}
+				bound[attr] = true
+				candidates = append(candidates, req)
+				attrs = append(attrs, attr)
+				candidatesBy = append(candidatesBy, pkg.Name)
+			}
+		}
+		inNixpkgs := nixpkgsHasAttrs(ctx, attrs, index, config)
+		var requirements, requiredBy []string
+		for i, req := range candidates {
+			if !inNixpkgs[attrs[i]] {
+				requirements = append(requirements, req.String())
+				requiredBy = append(requiredBy, candidatesBy[i])
+			}
+		}
+		deps := make([]PyPIPackageInfo, len(requirements))
+		if err := resolvePyPITasks(ctx, pypiPackageTasks(requirements, config.LanguageVersion, deps), progress); err != nil {
+			return nil, err
+		}
+		for i := range deps {
+			deps[i].RequiredBy = requiredBy[i]
+		}
+		packages = append(packages, deps...)
+		pending = deps
+	}
+	return orderPyPIPackages(packages), nil
+}
+
+// nixpkgsHasAttrs reports which attrs the Python package set of the channel
+// has. The package index decides when it can; the index of the curated
+// catalog cannot tell about other attrs, so they are checked with nix eval,
+// a few at a time. Attrs nix eval cannot check (no nix, no network) are
+// assumed to be in nixpkgs.
+func nixpkgsHasAttrs(ctx context.Context, attrs []string, index *PackageIndex, config models.UserConfig) map[string]bool {
+	has := make(map[string]bool, len(attrs))
+	var unchecked []string
+	for _, attr := range attrs {
+		_, ok := index.Lookup(attr, true)
+		if !ok && index.Source == CatalogIndexSource {
+			unchecked = append(unchecked, attr)
+			continue
+		}
+		has[attr] = ok
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, pypiWorkers)
+	for _, attr := range unchecked {
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			check := CheckAttrEval(ctx, config, attr, true)
+			mu.Lock()
+			defer mu.Unlock()
+			has[attr] = check.Known || check.Unchecked
+		})
+	}
+	wg.Wait()
+	return has
+}
+
+// orderPyPIPackages sorts packages topologically, so that the let block of
+// the flake lists every package after the PyPI packages it depends on. A
+// dependency closing a cycle is moved from RuntimeDeps to Cycles, since Nix
+// would recurse forever on it.
+func orderPyPIPackages(packages []PyPIPackageInfo) []PyPIPackageInfo {
+	byAttr := make(map[string]int, len(packages))
+	for i, pkg := range packages {
+		byAttr[pkg.Attr()] = i
+	}
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make([]int, len(packages))
+	ordered := make([]PyPIPackageInfo, 0, len(packages))
+	var visit func(i int)
+	visit = func(i int) {
+		state[i] = visiting
+		pkg := &packages[i]
+		// RuntimeDeps may be shared with the cache of ResolvePyPIPackage
+		pkg.RuntimeDeps = slices.Clone(pkg.RuntimeDeps)
+		pkg.RuntimeDeps = slices.DeleteFunc(pkg.RuntimeDeps, func(attr string) bool {
+			dep, ok := byAttr[attr]
+			if !ok {
+				return false
+			}
+			switch state[dep] {
+			case unvisited:
+				visit(dep)
+			case visiting:
+				pkg.Cycles = append(pkg.Cycles, attr)
+				return true
+			}
+			return false
+		})
+		state[i] = visited
+		ordered = append(ordered, *pkg)
+	}
+	for i := range packages {
+		if state[i] == unvisited {
+			visit(i)
+		}
+	}
+	return ordered
+}
@@ -0,0 +1,56 @@
+package nix
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// pypiProject is the PyPI response of a project whose only release, 1.0, has
+// one sdist and the given runtime dependencies.
+func pypiProject(name, requiresDist string) string {
+	return `{"info":{"name":"` + name + `","version":"1.0","requires_dist":[` + requiresDist + `]},` +
+		`"urls":[{"packagetype":"sdist","url":"https://files/` + name + `.tar.gz",` +
+		`"digests":{"sha256":"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"}}],` +
+		`"releases":{"1.0":[{"packagetype":"sdist"}]}}`
+}
+
+func TestResolvePyPIDependencies(t *testing.T) {
+	fakeHTTP(t, map[string]string{
+		"/pypi/treeapp/json":  pypiProject("treeapp", `"treedep>=1", "numpy"`),
+		"/pypi/treedep/json":  pypiProject("treedep", `"treeleaf"`),
+		"/pypi/treeleaf/json": pypiProject("treeleaf", ""),
+	})
+	// Without nix, attrs the catalog index does not know cannot be checked
+	t.Setenv("PATH", "")
+	config := models.UserConfig{LanguageVersion: "python312"}
+
+	tests := []struct {
+		name  string
+		index *PackageIndex
+		want  []string
+	}{
+		{"full index", &PackageIndex{Source: "test", Entries: []IndexEntry{{Attr: "numpy", Python: true}}},
+			[]string{"treeleaf", "treedep", "treeapp"}},
+		{"catalog index, unchecked attrs stay in nixpkgs", catalogIndex("nixos-unstable"),
+			[]string{"treeapp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := ResolvePyPIPackage(context.Background(), "treeapp", "python312")
+			packages, err := resolvePyPIDependencies(context.Background(), []PyPIPackageInfo{app}, tt.index, config, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, pkg := range packages {
+				got = append(got, pkg.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("packages = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
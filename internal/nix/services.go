@@ -0,0 +1,129 @@
+package nix
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// Services is the catalog of local services offered in the services step.
+// Scripts run under process-compose with the devShell's environment, so the
+// server binaries come from PATH and state paths from the exported Env.
+var Services = []models.Service{
+	{
+		Name:        "postgres",
+		NixAttr:     "postgresql",
+		Description: "PostgreSQL on 127.0.0.1:5432 (user postgres, no password)",
+		Env: []string{
+			"PGDATA=$DEVENV_STATE/postgres",
+			"PGHOST=$DEVENV_STATE",
+			"PGPORT=5432",
+			"PGUSER=postgres",
+			"DATABASE_URL=postgresql://postgres@127.0.0.1:5432/postgres",
+		},
+		Script: []string{
+			`if [ ! -f "$PGDATA/PG_VERSION" ]; then`,
+			`  initdb -D "$PGDATA" -U postgres --auth=trust --no-locale --encoding=UTF8`,
+			`fi`,
+			`exec postgres -D "$PGDATA" -k "$PGHOST" -p "$PGPORT" -c listen_addresses=127.0.0.1`,
+		},
+	},
+	{
+		Name:        "redis",
+		NixAttr:     "redis",
+		Description: "Redis on 127.0.0.1:6379",
+		Env: []string{
+			"REDIS_DIR=$DEVENV_STATE/redis",
+			"REDIS_URL=redis://127.0.0.1:6379",
+		},
+		Script: []string{
+			`mkdir -p "$REDIS_DIR"`,
+			`exec redis-server --bind 127.0.0.1 --port 6379 --dir "$REDIS_DIR"`,
+		},
+	},
+	{
+		Name:        "mysql",
+		NixAttr:     "mysql80",
+		Description: "MySQL 8.0 on 127.0.0.1:3306 (user root, no password)",
+		Env: []string{
+			"MYSQL_HOME=$DEVENV_STATE/mysql",
+			"MYSQL_UNIX_PORT=$DEVENV_STATE/mysql.sock",
+			"MYSQL_TCP_PORT=3306",
+			"MYSQL_URL=mysql://root@127.0.0.1:3306/",
+		},
+		Script: []string{
+			`if [ ! -d "$MYSQL_HOME/data" ]; then`,
+			`  mkdir -p "$MYSQL_HOME"`,
+			`  mysqld --initialize-insecure --datadir="$MYSQL_HOME/data"`,
+			`fi`,
+			`exec mysqld --datadir="$MYSQL_HOME/data" --socket="$MYSQL_UNIX_PORT" --bind-address=127.0.0.1 --port="$MYSQL_TCP_PORT" --mysqlx=OFF`,
+		},
+	},
+	{
+		Name:        "minio",
+		NixAttr:     "minio",
+		Description: "MinIO S3 API on 127.0.0.1:9000, console on :9001",
+		Env: []string{
+			"MINIO_ROOT_USER=minioadmin",
+			"MINIO_ROOT_PASSWORD=minioadmin",
+			"AWS_ENDPOINT_URL=http://127.0.0.1:9000",
+			"AWS_ACCESS_KEY_ID=minioadmin",
+			"AWS_SECRET_ACCESS_KEY=minioadmin",
+		},
+		Script: []string{
+			`mkdir -p "$DEVENV_STATE/minio"`,
+			`exec minio server "$DEVENV_STATE/minio" --address 127.0.0.1:9000 --console-address 127.0.0.1:9001`,
+		},
+	},
+	{
+		Name:        "mailpit",
+		NixAttr:     "mailpit",
+		Description: "Mailpit SMTP sink on 127.0.0.1:1025, web UI on :8025",
+		Env: []string{
+			"SMTP_HOST=127.0.0.1",
+			"SMTP_PORT=1025",
+		},
+		Script: []string{
+			`exec mailpit --smtp 127.0.0.1:1025 --listen 127.0.0.1:8025 --database "$DEVENV_STATE/mailpit.db"`,
+		},
+	},
+}
+
+// ServiceData holds one process-compose process of the template data.
+type ServiceData struct {
+	Name   string
+	Script []string // escaped for a '' string
+}
+
+// ServicesData holds the process-compose part of the template data.
+type ServicesData struct {
+	Processes []ServiceData
+	Env       []string // NAME="value" pairs for export, escaped for a '' string
+}
+
+// servicesData resolves the selected services in catalog order. It returns
+// nil when no service is selected, plus the packages the shell needs.
+func servicesData(config models.UserConfig) (*ServicesData, []string) {
+	var data ServicesData
+	var attrs []string
+	for _, service := range Services {
+		if !slices.Contains(config.Services, service.Name) {
+			continue
+		}
+		data.Processes = append(data.Processes, ServiceData{
+			Name:   service.Name,
+			Script: escapeShellHook(service.Script),
+		})
+		for _, env := range service.Env {
+			name, value, _ := strings.Cut(env, "=")
+			data.Env = append(data.Env, name+`="`+value+`"`)
+		}
+		attrs = append(attrs, service.NixAttr)
+	}
+	if len(data.Processes) == 0 {
+		return nil, nil
+	}
+	data.Env = escapeShellHook(data.Env)
+	return &data, append(attrs, "process-compose")
+}
@@ -0,0 +1,180 @@
+package nix
+
+import (
+	"regexp"
+	"slices"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// ShellData holds the per-devShell part of the template data.
+type ShellData struct {
+	Name           string   // devShells attribute (e.g., "default", "ci")
+	PythonEnv      string   // let binding holding this shell's Python environment
+	SystemPackages []string // pkgs.* items; on top of commonPackages when there are several shells
+	Root           *FlakeTemplateData
+}
+
+// PythonEnvData is one python.withPackages binding when several shells are generated.
+type PythonEnvData struct {
+	Name     string   // let binding (e.g., "pythonEnv", "pythonEnv-ci")
+	Packages []string // packages on top of commonPythonPackages
+}
+
+// shellSpec is the resolved package selection of one devShell.
+type shellSpec struct {
+	name   string
+	python []string
+	system []string
+}
+
+// shellNameRe matches names usable as devShells attributes without quoting.
+var shellNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_'-]*$`)
+
+// ValidShellName reports whether name can be used as a devShells attribute.
+func ValidShellName(name string) bool {
+	return shellNameRe.MatchString(name) && name != "default"
+}
+
+// WithoutEditors returns tools minus the ones in the "Editors" category of CommonTools.
+func WithoutEditors(tools []string) []string {
+	kept := make([]string, 0, len(tools))
+	for _, attr := range tools {
+		if tool, ok := findPackage(CommonTools, attr); ok && tool.Category == "Editors" {
+			continue
+		}
+		kept = append(kept, attr)
+	}
+	return kept
+}
+
+// ShellFeatures are the features a shell profile enables on its own. They
+// swap packages for accelerator variants and add their attrs to that shell
+// only; nixpkgs' cudaSupport and rocmSupport still follow the default shell.
+var ShellFeatures = []string{FeatureCUDA, FeatureROCm}
+
+// profileConfig returns config as an extra shell sees it: the packages, tools
+// and per-shell features of profile replace those of the default shell.
+func profileConfig(config models.UserConfig, profile models.ShellProfile) models.UserConfig {
+	config.Packages = profile.Packages
+	config.Tools = profile.Tools
+	config.UseCUDA = slices.Contains(profile.Features, FeatureCUDA)
+	config.UseROCm = slices.Contains(profile.Features, FeatureROCm)
+
+	lang, _ := GetLanguage(config.Language)
+	var shellAttrs, enabledAttrs []string
+	for _, feature := range lang.SpecialFeatures {
+		if slices.Contains(ShellFeatures, feature.Name) {
+			shellAttrs = append(shellAttrs, feature.NixAttrs...)
+			if slices.Contains(profile.Features, feature.Name) {
+				enabledAttrs = append(enabledAttrs, feature.NixAttrs...)
+			}
+		}
+	}
+	config.EnabledFeatures = append(subtractStrings(config.EnabledFeatures, shellAttrs), enabledAttrs...)
+	return config
+}
+
+// anyShellUses reports whether the default shell or a profile enables a
+// per-shell feature.
+func anyShellUses(config models.UserConfig, feature string) bool {
+	enabled := map[string]bool{FeatureCUDA: config.UseCUDA, FeatureROCm: config.UseROCm}[feature]
+	return enabled || slices.ContainsFunc(config.ExtraShells, func(profile models.ShellProfile) bool {
+		return slices.Contains(profile.Features, feature)
+	})
+}
+
+// extraShellSpecs resolves the package selection of the extra shell profiles
+// the same way GenerateFlake resolves the default shell.
+func extraShellSpecs(config models.UserConfig, cudaSet string, serviceAttrs []string, inputs []InputData) []shellSpec {
+	specs := make([]shellSpec, 0, len(config.ExtraShells))
+	for _, profile := range config.ExtraShells {
+		shellConfig := profileConfig(config, profile)
+		system := []string{"zlib"}
+		system = append(system, shellConfig.Tools...)
+		system = append(system, shellConfig.EnabledFeatures...)
+		system = append(system, serviceAttrs...)
+		swaps := AcceleratorSwaps(shellConfig)
+		specs = append(specs, shellSpec{
+			name:   profile.Name,
+			python: sourcedAttrs(applySwaps(shellConfig.Packages, swaps), swaps, config, inputs, true),
+			system: sourcedAttrs(withCUDASet(uniqueStrings(system), cudaSet), nil, config, inputs, false),
+		})
+	}
+	return specs
+}
+
+// layoutShells splits the shells' package lists into the parts shared by all
+// shells and the per-shell remainder. The first spec is devShells.default.
+// A single shell keeps everything in its own lists.
+func layoutShells(specs []shellSpec) (commonPython, commonSystem []string, envs []PythonEnvData, shells []ShellData) {
+	if len(specs) == 1 {
+		return specs[0].python, nil, nil, []ShellData{{
+			Name:           "default",
+			PythonEnv:      "pythonEnv",
+			SystemPackages: specs[0].system,
+		}}
+	}
+
+	commonPython = specs[0].python
+	commonSystem = specs[0].system
+	for _, spec := range specs[1:] {
+		commonPython = intersectStrings(commonPython, spec.python)
+		commonSystem = intersectStrings(commonSystem, spec.system)
+	}
+
+	for i, spec := range specs {
+		env := "pythonEnv"
+		if i > 0 && !equalStrings(spec.python, specs[0].python) {
+			env = "pythonEnv-" + spec.name
+		}
+		if i == 0 || env != "pythonEnv" {
+			envs = append(envs, PythonEnvData{Name: env, Packages: subtractStrings(spec.python, commonPython)})
+		}
+		name := spec.name
+		if i == 0 {
+			name = "default"
+		}
+		shells = append(shells, ShellData{
+			Name:           name,
+			PythonEnv:      env,
+			SystemPackages: subtractStrings(spec.system, commonSystem),
+		})
+	}
+	return commonPython, commonSystem, envs, shells
+}
+
+// intersectStrings returns the items of a that are also in b, in a's order.
+func intersectStrings(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, item := range b {
+		inB[item] = true
+	}
+	var both []string
+	for _, item := range a {
+		if inB[item] {
+			both = append(both, item)
+		}
+	}
+	return both
+}
+
+// subtractStrings returns the items of a that are not in b, in a's order.
+func subtractStrings(a, b []string) []string {
+	inB := make(map[string]bool, len(b))
+	for _, item := range b {
+		inB[item] = true
+	}
+	var rest []string
+	for _, item := range a {
+		if !inB[item] {
+			rest = append(rest, item)
+		}
+	}
+	return rest
+}
+
+// equalStrings reports whether a and b hold the same items, ignoring order.
+func equalStrings(a, b []string) bool {
+	return len(subtractStrings(a, b)) == 0 && len(subtractStrings(b, a)) == 0
+}
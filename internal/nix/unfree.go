@@ -0,0 +1,65 @@
+package nix
+
+import (
+	"slices"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// UnfreePackage is catalog metadata for an attr whose build pulls in unfree packages.
+type UnfreePackage struct {
+	License string   // unfree license accepted by selecting the attr (e.g., "CUDA EULA")
+	Names   []string // package names (lib.getName) allowUnfreePredicate must accept
+}
+
+// cudaRedistNames are the unfree CUDA redistributables that cudaSupport builds
+// and the CUDA toolkit pull in.
+var cudaRedistNames = []string{
+	"cuda-merged", "cudatoolkit",
+	"cuda_cccl", "cuda_cudart", "cuda_cuobjdump", "cuda_cupti", "cuda_cuxxfilt",
+	"cuda_gdb", "cuda_nvcc", "cuda_nvdisasm", "cuda_nvml_dev", "cuda_nvprune",
+	"cuda_nvrtc", "cuda_nvtx", "cuda_profiler_api", "cuda_sanitizer_api",
+	"libcublas", "libcufft", "libcufile", "libcurand", "libcusolver",
+	"libcusparse", "libcusparse_lt", "libnpp", "libnvjitlink",
+}
+
+// UnfreePackages maps catalog attrs (Python packages, tools and feature attrs)
+// to the unfree packages they need. Attrs not listed are free.
+var UnfreePackages = map[string]UnfreePackage{
+	"cudaPackages.cudatoolkit": {License: "CUDA EULA", Names: cudaRedistNames},
+	"cudaPackages.cudnn":       {License: "cuDNN EULA", Names: []string{"cudnn"}},
+	"torchWithCuda":            {License: "CUDA EULA", Names: append([]string{"cudnn"}, cudaRedistNames...)},
+	"tensorflowWithCuda":       {License: "CUDA EULA", Names: append([]string{"cudnn"}, cudaRedistNames...)},
+	"jaxlibWithCuda":           {License: "CUDA EULA", Names: append([]string{"cudnn"}, cudaRedistNames...)},
+	"torch-bin":                {License: "CUDA EULA", Names: append([]string{"cudnn"}, cudaRedistNames...)},
+	"torchaudio-bin":           {License: "CUDA EULA", Names: append([]string{"cudnn"}, cudaRedistNames...)},
+	"tensorflow-bin":           {License: "CUDA EULA", Names: append([]string{"cudnn"}, cudaRedistNames...)},
+	"jaxlib-bin":               {License: "CUDA EULA", Names: append([]string{"cudnn"}, cudaRedistNames...)},
+}
+
+// UnfreeAllowances returns the sorted package names allowUnfreePredicate must
+// accept for the selection (all shells, after accelerator swaps), and the
+// unfree licenses they carry in selection order.
+func UnfreeAllowances(config models.UserConfig) (names, licenses []string) {
+	attrs := applySwaps(config.Packages, AcceleratorSwaps(config))
+	attrs = append(attrs, config.Tools...)
+	attrs = append(attrs, config.EnabledFeatures...)
+	for _, profile := range config.ExtraShells {
+		shellConfig := profileConfig(config, profile)
+		attrs = append(attrs, applySwaps(shellConfig.Packages, AcceleratorSwaps(shellConfig))...)
+		attrs = append(attrs, shellConfig.Tools...)
+		attrs = append(attrs, shellConfig.EnabledFeatures...)
+	}
+
+	for _, attr := range attrs {
+		unfree, ok := UnfreePackages[attr]
+		if !ok {
+			continue
+		}
+		names = append(names, unfree.Names...)
+		licenses = append(licenses, unfree.License)
+	}
+	names = uniqueStrings(names)
+	slices.Sort(names)
+	return names, uniqueStrings(licenses)
+}
@@ -0,0 +1,489 @@
+package nix
+
+import (
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+// Severity tells whether a Diagnostic blocks generation.
+type Severity int
+
+const (
+	SeverityWarning Severity = iota // the flake builds, but likely not as intended
+	SeverityError                   // the flake would fail to evaluate or build
+)
+
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic is a problem Validate found in a configuration.
+type Diagnostic struct {
+	Severity Severity
+	Message  string
+	FixHint  string                                    // what Fix changes, e.g. "drop soxr from the tools"
+	Fix      func(models.UserConfig) models.UserConfig // nil when the problem needs a manual change
+}
+
+// maxFixRounds bounds AutoFix; every fix removes its own diagnostic, so this
+// only guards against fixes that undo each other.
+const maxFixRounds = 32
+
+// Validate checks a custom-mode configuration for conflicts the generated flake
+// would run into. Errors come first.
+func Validate(config models.UserConfig) []Diagnostic {
+	if config.Mode == "quick" {
+		return nil
+	}
+	var diagnostics []Diagnostic
+	for _, check := range []func(models.UserConfig) []Diagnostic{
+		checkAvailability,
+		checkPackageToolOverlap,
+		checkPythonSources,
+		checkEnvVars,
+		checkAccelerators,
+		checkCUDABuilds,
+		checkShellFeatures,
+		checkImage,
+	} {
+		diagnostics = append(diagnostics, check(config)...)
+	}
+	slices.SortStableFunc(diagnostics, func(a, b Diagnostic) int { return int(b.Severity) - int(a.Severity) })
+	return diagnostics
+}
+
+// HasErrors reports whether any diagnostic is an error.
+func HasErrors(diagnostics []Diagnostic) bool {
+	return slices.ContainsFunc(diagnostics, func(d Diagnostic) bool { return d.Severity == SeverityError })
+}
+
+// AutoFix applies the fixes of config's diagnostics one at a time, validating
+// again after each, and returns the fixed config and the number of fixes applied.
+func AutoFix(config models.UserConfig) (models.UserConfig, int) {
+	applied := 0
+	for range maxFixRounds {
+		idx := slices.IndexFunc(Validate(config), func(d Diagnostic) bool { return d.Fix != nil })
+		if idx < 0 {
+			break
+		}
+		config = Validate(config)[idx].Fix(config)
+		applied++
+	}
+	return config, applied
+}
+
+// checkAvailability flags catalog packages and tools the channel does not
+// have; renamed ones are fixed by switching to the new attr.
+func checkAvailability(config models.UserConfig) []Diagnostic {
+	var diagnostics []Diagnostic
+	check := func(attrs []string, python bool, kind string) {
+		for _, attr := range attrs {
+			availability := AttrAvailability(config.NixpkgsURL, attr, python)
+			if availability.Available {
+				continue
+			}
+			d := Diagnostic{
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("%s %s is not available on %s (%s)", kind, attr, channelName(config.NixpkgsURL), availability.Reason),
+				FixHint:  fmt.Sprintf("drop %s", attr),
+				Fix:      func(c models.UserConfig) models.UserConfig { return replaceAttr(c, attr, "", python) },
+			}
+			if to := availability.RenamedTo; to != "" {
+				d.FixHint = fmt.Sprintf("use %s instead", to)
+				d.Fix = func(c models.UserConfig) models.UserConfig { return replaceAttr(c, attr, to, python) }
+			}
+			diagnostics = append(diagnostics, d)
+		}
+	}
+	packages, tools := slices.Clone(config.Packages), slices.Clone(config.Tools)
+	for _, profile := range config.ExtraShells {
+		packages = append(packages, profile.Packages...)
+		tools = append(tools, profile.Tools...)
+	}
+	check(uniqueStrings(packages), true, "Python package")
+	check(uniqueStrings(tools), false, "Tool")
+	return diagnostics
+}
+
+// channelName returns the branch of a nixpkgs URL for messages.
+func channelName(nixpkgsURL string) string {
+	if nixpkgsURL == "" {
+		return "nixos-unstable"
+	}
+	return ChannelBranch(nixpkgsURL)
+}
+
+// replaceAttr replaces attr by to in the package (python) or tool lists of
+// all shells, moving its input source and overrides; an empty to drops it.
+func replaceAttr(config models.UserConfig, attr, to string, python bool) models.UserConfig {
+	replace := func(attrs []string) []string {
+		var replaced []string
+		for _, a := range attrs {
+			switch {
+			case a != attr:
+				replaced = append(replaced, a)
+			case to != "" && !slices.Contains(attrs, to):
+				replaced = append(replaced, to)
+			}
+		}
+		return replaced
+	}
+
+	config.ExtraShells = slices.Clone(config.ExtraShells)
+	if python {
+		config.Packages = replace(config.Packages)
+		for i := range config.ExtraShells {
+			config.ExtraShells[i].Packages = replace(config.ExtraShells[i].Packages)
+		}
+		if override, ok := config.PackageOverrides[attr]; ok {
+			config.PackageOverrides = cloneMap(config.PackageOverrides)
+			delete(config.PackageOverrides, attr)
+			if to != "" {
+				config.PackageOverrides[to] = override
+			}
+		}
+	} else {
+		config.Tools = replace(config.Tools)
+		for i := range config.ExtraShells {
+			config.ExtraShells[i].Tools = replace(config.ExtraShells[i].Tools)
+		}
+	}
+	if source, ok := config.PackageSources[attr]; ok {
+		config.PackageSources = cloneMap(config.PackageSources)
+		delete(config.PackageSources, attr)
+		if to != "" {
+			config.PackageSources[to] = source
+		}
+	}
+	return config
+}
+
+// cloneMap copies a map so fixes do not modify the config they were given.
+func cloneMap[V any](m map[string]V) map[string]V {
+	clone := make(map[string]V, len(m))
+	for k, v := range m {
+		clone[k] = v
+	}
+	return clone
+}
+
+// checkPackageToolOverlap flags attrs selected both as a Python package and as
+// a tool: the shell gets two unrelated builds of the same name (e.g., soxr the
+// Python binding and soxr the C library, or two black executables on PATH).
+func checkPackageToolOverlap(config models.UserConfig) []Diagnostic {
+	var diagnostics []Diagnostic
+	for _, attr := range config.Tools {
+		if !slices.Contains(config.Packages, attr) {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityWarning,
+			Message:  fmt.Sprintf("%s is selected as a Python package and as a tool (%sPackages.%s and pkgs.%s)", attr, config.LanguageVersion, attr, attr),
+			FixHint:  fmt.Sprintf("drop %s from the tools", attr),
+			Fix: func(c models.UserConfig) models.UserConfig {
+				c.Tools = slices.DeleteFunc(slices.Clone(c.Tools), func(a string) bool { return a == attr })
+				return c
+			},
+		})
+	}
+	return diagnostics
+}
+
+// checkPythonSources rejects Python packages taken from an extra nixpkgs input:
+// its packages are built for that input's interpreter, which would end up in
+// the environment next to the main one.
+func checkPythonSources(config models.UserConfig) []Diagnostic {
+	python := slices.Clone(config.Packages)
+	for _, profile := range config.ExtraShells {
+		python = append(python, profile.Packages...)
+	}
+	var diagnostics []Diagnostic
+	for _, attr := range slices.Sorted(maps.Keys(config.PackageSources)) {
+		source := config.PackageSources[attr]
+		idx := slices.IndexFunc(config.Inputs, func(input models.FlakeInput) bool { return input.Name == source })
+		if idx < 0 || !IsNixpkgsURL(config.Inputs[idx].URL) || !slices.Contains(python, attr) {
+			continue
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("Python package %s comes from the nixpkgs input %s, which builds it for its own interpreter, not %s", attr, source, config.LanguageVersion),
+			FixHint:  fmt.Sprintf("take %s from nixpkgs", attr),
+			Fix: func(c models.UserConfig) models.UserConfig {
+				c.PackageSources = cloneMap(c.PackageSources)
+				delete(c.PackageSources, attr)
+				return c
+			},
+		})
+	}
+	return diagnostics
+}
+
+// envVarNameRe matches names usable both as shell variables and as mkShell attrs.
+var envVarNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// mkShellAttrs are mkShell arguments the generated devShells set or that
+// change its meaning, so env vars cannot use them.
+var mkShellAttrs = []string{"buildInputs", "env", "inputsFrom", "name", "nativeBuildInputs", "packages", "shellHook"}
+
+// checkEnvVars flags env var names that would redefine an attribute of the
+// devShell: duplicates, mkShell arguments and names that are not identifiers.
+func checkEnvVars(config models.UserConfig) []Diagnostic {
+	var diagnostics []Diagnostic
+	seen := make(map[string]bool)
+	for _, name := range config.EnvVars {
+		switch {
+		case seen[name]:
+			diagnostics = append(diagnostics, Diagnostic{
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Environment variable %s is defined twice (attribute already defined)", name),
+				FixHint:  fmt.Sprintf("keep one %s", name),
+				Fix: func(c models.UserConfig) models.UserConfig {
+					c.EnvVars = uniqueStrings(c.EnvVars)
+					return c
+				},
+			})
+		case !envVarNameRe.MatchString(name):
+			diagnostics = append(diagnostics, Diagnostic{
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Environment variable %q is not a valid name (letters, digits and _)", name),
+			})
+		case slices.Contains(mkShellAttrs, name):
+			diagnostics = append(diagnostics, Diagnostic{
+				Severity: SeverityError,
+				Message:  fmt.Sprintf("Environment variable %s would override the devShell's own %s attribute", name, name),
+			})
+		}
+		seen[name] = true
+	}
+	return diagnostics
+}
+
+// darwinSystems are the systems of flake-utils' eachDefaultSystem without CUDA or ROCm.
+var darwinSystems = []string{"aarch64-darwin", "x86_64-darwin"}
+
+// checkAccelerators flags accelerator features that cannot apply everywhere.
+func checkAccelerators(config models.UserConfig) []Diagnostic {
+	var diagnostics []Diagnostic
+	if config.UseCUDA && config.UseROCm {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityWarning,
+			Message:  "CUDA and ROCm are both enabled; packages get the CUDA variants and nixpkgs builds with both",
+			FixHint:  "turn off ROCm support",
+			Fix:      func(c models.UserConfig) models.UserConfig { return disableFeature(c, FeatureROCm) },
+		})
+	}
+	var accelerators []string
+	if anyShellUses(config, FeatureCUDA) {
+		accelerators = append(accelerators, "CUDA")
+	}
+	if anyShellUses(config, FeatureROCm) {
+		accelerators = append(accelerators, "ROCm")
+	}
+	if len(accelerators) > 0 {
+		verb := "builds"
+		if len(accelerators) > 1 {
+			verb = "build"
+		}
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityWarning,
+			Message: fmt.Sprintf("%s only %s on Linux, but eachDefaultSystem also generates devShells for %s; they fail to evaluate there (e.g., in 'nix flake check')",
+				strings.Join(accelerators, " and "), verb, strings.Join(darwinSystems, " and ")),
+		})
+	}
+	return diagnostics
+}
+
+// cudaRuntimeOwners maps packages built against another package's CUDA
+// runtime to that package (torchaudio-bin is built for torch-bin).
+var cudaRuntimeOwners = map[string]string{"torchaudio": "torch"}
+
+// cudaSupportFollowers are packages without a …WithCuda variant whose plain
+// attr is built against CUDA when nixpkgs' cudaSupport is set (torchaudio
+// follows torch.cudaSupport).
+var cudaSupportFollowers = map[string]bool{"torchaudio": true}
+
+// checkCUDABuilds flags CUDA environments that mix CUDA runtimes: a package
+// without a variant for the chosen build (that does not follow cudaSupport
+// either) staying on CPU next to swapped ones, and prebuilt frameworks that
+// each bundle their own CUDA runtime.
+func checkCUDABuilds(config models.UserConfig) []Diagnostic {
+	if !config.UseCUDA {
+		return nil
+	}
+	lang, ok := GetLanguage(config.Language)
+	if !ok {
+		return nil
+	}
+	prebuilt := config.FeatureParams[ParamCUDABuild] == "prebuilt"
+	build, variant, otherBuild, other := "source", "cuda", "prebuilt", "cuda-bin"
+	if prebuilt {
+		build, variant, otherBuild, other = "prebuilt", "cuda-bin", "source", "cuda"
+	}
+
+	// Selected packages that have a CUDA variant of either build
+	var capable []models.Package
+	for _, attr := range config.Packages {
+		if pkg, ok := findPackage(lang.CommonPackages, attr); ok && (pkg.Variants["cuda"] != "" || pkg.Variants["cuda-bin"] != "") {
+			capable = append(capable, pkg)
+		}
+	}
+	// Source builds also cover the packages that follow cudaSupport
+	hasBuild := func(pkg models.Package, variant string) bool {
+		return pkg.Variants[variant] != "" || variant == "cuda" && cudaSupportFollowers[pkg.NixAttr]
+	}
+	switchable := !slices.ContainsFunc(capable, func(pkg models.Package) bool { return !hasBuild(pkg, other) })
+	switchBuild := func(c models.UserConfig) models.UserConfig {
+		c.FeatureParams = cloneMap(c.FeatureParams)
+		c.FeatureParams[ParamCUDABuild] = otherBuild
+		return c
+	}
+	withFix := func(d Diagnostic) Diagnostic {
+		if switchable {
+			d.FixHint = fmt.Sprintf("use %s CUDA builds", otherBuild)
+			d.Fix = switchBuild
+		}
+		return d
+	}
+
+	var diagnostics []Diagnostic
+	var swapped, unswapped, runtimes []string
+	for _, pkg := range capable {
+		if to := pkg.Variants[variant]; to != "" {
+			swapped = append(swapped, to)
+			if cudaRuntimeOwners[pkg.NixAttr] == "" {
+				runtimes = append(runtimes, to)
+			}
+		} else if !hasBuild(pkg, variant) {
+			unswapped = append(unswapped, pkg.NixAttr)
+		}
+	}
+	if len(swapped) > 0 && len(unswapped) > 0 {
+		diagnostics = append(diagnostics, withFix(Diagnostic{
+			Severity: SeverityError,
+			Message: fmt.Sprintf("%s has no %s CUDA build and stays a CPU build next to %s; the environment mixes CUDA and CPU builds",
+				strings.Join(unswapped, ", "), build, strings.Join(swapped, ", ")),
+		}))
+	}
+	if prebuilt && len(runtimes) > 1 {
+		diagnostics = append(diagnostics, withFix(Diagnostic{
+			Severity: SeverityWarning,
+			Message: fmt.Sprintf("%s bundle different CUDA runtimes; importing them in one process can fail",
+				strings.Join(runtimes, " and ")),
+		}))
+	}
+	return diagnostics
+}
+
+// checkShellFeatures flags shell features that do not combine.
+func checkShellFeatures(config models.UserConfig) []Diagnostic {
+	var diagnostics []Diagnostic
+	if config.UseUv2nix {
+		for _, feature := range []struct {
+			enabled bool
+			name    string
+		}{{config.UseFHS, FeatureFHS}, {config.UseVenv, FeatureVenv}} {
+			if !feature.enabled {
+				continue
+			}
+			diagnostics = append(diagnostics, Diagnostic{
+				Severity: SeverityWarning,
+				Message:  fmt.Sprintf("%s is ignored with uv2nix, whose devShell is a plain mkShell around the uv2nix virtualenv", feature.name),
+				FixHint:  "turn off " + feature.name,
+				Fix:      func(c models.UserConfig) models.UserConfig { return disableFeature(c, feature.name) },
+			})
+		}
+		return diagnostics
+	}
+	if config.UseFHS && config.UseVenv {
+		diagnostics = append(diagnostics, Diagnostic{
+			Severity: SeverityWarning,
+			Message:  "FHS environment with the venv hook: the FHS already provides /usr/lib for wheels, and the hook's LD_LIBRARY_PATH shadows it",
+			FixHint:  "turn off " + FeatureVenv,
+			Fix:      func(c models.UserConfig) models.UserConfig { return disableFeature(c, FeatureVenv) },
+		})
+	}
+	return diagnostics
+}
+
+// checkImage flags an image entrypoint that does not parse as a command line.
+func checkImage(config models.UserConfig) []Diagnostic {
+	if !config.UseImage {
+		return nil
+	}
+	entrypoint := config.FeatureParams[ParamImageEntrypoint]
+	if _, err := splitShellWords(entrypoint); err != nil {
+		return []Diagnostic{{
+			Severity: SeverityError,
+			Message:  fmt.Sprintf("image entrypoint %q: %v", entrypoint, err),
+		}}
+	}
+	return nil
+}
+
+// disableFeature turns a feature off: its UseX flag and the attrs it added,
+// except those another enabled feature needs too (uv for venv and uv2nix).
+func disableFeature(config models.UserConfig, name string) models.UserConfig {
+	switch name {
+	case FeatureCUDA:
+		config.UseCUDA = false
+	case FeatureROCm:
+		config.UseROCm = false
+	case FeatureFHS:
+		config.UseFHS = false
+	case FeatureVenv:
+		config.UseVenv = false
+	case FeatureUv2nix:
+		config.UseUv2nix = false
+	case FeatureChecks:
+		config.UseChecks = false
+	case FeatureImage:
+		config.UseImage = false
+	case FeatureGitHooks:
+		config.UseGitHooks = false
+	}
+	lang, _ := GetLanguage(config.Language)
+	var added, needed []string
+	for _, feature := range lang.SpecialFeatures {
+		switch {
+		case feature.Name == name:
+			added = feature.NixAttrs
+		case featureEnabled(config, feature.Name):
+			needed = append(needed, feature.NixAttrs...)
+		}
+	}
+	config.EnabledFeatures = slices.DeleteFunc(slices.Clone(config.EnabledFeatures), func(attr string) bool {
+		return slices.Contains(added, attr) && !slices.Contains(needed, attr)
+	})
+	return config
+}
+
+// featureEnabled reports whether the UseX flag of a feature is set.
+func featureEnabled(config models.UserConfig, name string) bool {
+	switch name {
+	case FeatureCUDA:
+		return config.UseCUDA
+	case FeatureROCm:
+		return config.UseROCm
+	case FeatureFHS:
+		return config.UseFHS
+	case FeatureVenv:
+		return config.UseVenv
+	case FeatureUv2nix:
+		return config.UseUv2nix
+	case FeatureChecks:
+		return config.UseChecks
+	case FeatureImage:
+		return config.UseImage
+	case FeatureGitHooks:
+		return config.UseGitHooks
+	}
+	return false
+}
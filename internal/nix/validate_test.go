@@ -0,0 +1,76 @@
+package nix
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/mmxgn/manos-nix-template-builder/internal/models"
+)
+
+func TestCheckCUDABuilds(t *testing.T) {
+	tests := []struct {
+		name     string
+		packages []string
+		build    string
+		want     []Severity
+	}{
+		{"torchaudio follows torch.cudaSupport", []string{"torch", "torchaudio"}, "source", nil},
+		{"prebuilt torch and torchaudio share a runtime", []string{"torch", "torchaudio"}, "prebuilt", nil},
+		{"prebuilt frameworks bundle their own runtimes", []string{"torch", "tensorflow"}, "prebuilt", []Severity{SeverityWarning}},
+		{"source builds only", []string{"torch", "jaxlib"}, "source", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diagnostics := checkCUDABuilds(models.UserConfig{
+				Language:        "python",
+				LanguageVersion: "python312",
+				Packages:        tt.packages,
+				UseCUDA:         true,
+				FeatureParams:   map[string]string{ParamCUDABuild: tt.build},
+			})
+			var got []Severity
+			for _, d := range diagnostics {
+				got = append(got, d.Severity)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("severities = %v, want %v: %+v", got, tt.want, diagnostics)
+			}
+		})
+	}
+}
+
+func TestValidatePythonFromNixpkgsInput(t *testing.T) {
+	config := models.UserConfig{
+		Language:        "python",
+		LanguageVersion: "python312",
+		Packages:        []string{"numpy"},
+		Tools:           []string{"git"},
+		Inputs:          []models.FlakeInput{{Name: "stable", URL: "github:NixOS/nixpkgs/nixos-24.05"}},
+		PackageSources:  map[string]string{"numpy": "stable", "git": "stable"},
+	}
+	diagnostics := checkPythonSources(config)
+	if len(diagnostics) != 1 || diagnostics[0].Severity != SeverityError {
+		t.Fatalf("diagnostics = %+v, want one error for numpy", diagnostics)
+	}
+	fixed := diagnostics[0].Fix(config)
+	if _, ok := fixed.PackageSources["numpy"]; ok || fixed.PackageSources["git"] != "stable" {
+		t.Errorf("fixed sources = %v, want only git from stable", fixed.PackageSources)
+	}
+}
+
+func TestAutoFixUv2nixWithVenv(t *testing.T) {
+	config, applied := AutoFix(models.UserConfig{
+		Mode:            "custom",
+		Language:        "python",
+		LanguageVersion: "python312",
+		UseVenv:         true,
+		UseUv2nix:       true,
+		EnabledFeatures: []string{"uv"},
+	})
+	if applied != 1 || config.UseVenv || !config.UseUv2nix {
+		t.Errorf("applied %d fixes, venv %v, uv2nix %v; want venv turned off only", applied, config.UseVenv, config.UseUv2nix)
+	}
+	if !slices.Contains(config.EnabledFeatures, "uv") {
+		t.Errorf("uv removed although uv2nix still needs it: %v", config.EnabledFeatures)
+	}
+}
@@ -0,0 +1,155 @@
+package nix
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// defaultSystems are the systems flake-utils.lib.eachDefaultSystem builds the flake for.
+var defaultSystems = []string{"x86_64-linux", "aarch64-linux", "x86_64-darwin", "aarch64-darwin"}
+
+// PyPIWheel is a wheel of a PyPI release, downloaded with fetchPypi's wheel format.
+type PyPIWheel struct {
+	System   string // Nix system it installs on; empty for a pure-Python wheel
+	Pname    string // distribution name in the file name, e.g. "pydantic_core"
+	Dist     string // python_version of the file on PyPI, the directory fetchPypi downloads from
+	Python   string // python tag, e.g. "cp312" or "py3"
+	ABI      string // abi tag, e.g. "cp312", "abi3" or "none"
+	Platform string // platform tag, e.g. "manylinux_2_17_x86_64.manylinux2014_x86_64" or "any"
+	HashExpr string // Nix expression: quoted "sha256-..."
+}
+
+// PureWheel reports whether the package installs from a single pure-Python wheel.
+func (p PyPIPackageInfo) PureWheel() bool {
+	return len(p.Wheels) == 1 && p.Wheels[0].System == ""
+}
+
+// HasLinuxWheels reports whether the package installs from manylinux wheels,
+// which need autoPatchelfHook to find their shared libraries.
+func (p PyPIPackageInfo) HasLinuxWheels() bool {
+	for _, wheel := range p.Wheels {
+		if strings.HasSuffix(wheel.System, "-linux") {
+			return true
+		}
+	}
+	return false
+}
+
+// wheelFileRe splits a wheel file name without build tag into distribution,
+// version, python, abi and platform tags.
+var wheelFileRe = regexp.MustCompile(`^([^-]+)-([^-]+)-([^-]+)-([^-]+)-([^-]+)\.whl$`)
+
+// selectWheels picks the wheels to install a release from when it has no
+// sdist: a pure-Python py3-none-any wheel, or else the best platform wheel for
+// the interpreter of pythonAttr (e.g. "python312") on each default system.
+// Systems without a compatible wheel are left out.
+func selectWheels(files []pypiFile, pythonAttr string) ([]PyPIWheel, error) {
+	var wheels []PyPIWheel
+	for _, f := range files {
+		if f.PackageType != "bdist_wheel" || f.Yanked {
+			continue
+		}
+		m := wheelFileRe.FindStringSubmatch(f.Filename)
+		if m == nil {
+			continue
+		}
+		sri, err := hexSHA256ToSRI(f.Digests.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		wheels = append(wheels, PyPIWheel{
+			Pname:    m[1],
+			Dist:     f.PythonVersion,
+			Python:   m[3],
+			ABI:      m[4],
+			Platform: m[5],
+			HashExpr: fmt.Sprintf(`"sha256-%s"`, sri),
+		})
+	}
+
+	for _, wheel := range wheels {
+		if wheel.ABI == "none" && wheel.Platform == "any" && hasTag(wheel.Python, "py3") {
+			return []PyPIWheel{wheel}, nil
+		}
+	}
+
+	minor := pythonMinor(pythonAttr)
+	var selected []PyPIWheel
+	for _, system := range defaultSystems {
+		best, bestRank := -1, 0
+		for i, wheel := range wheels {
+			if !platformMatches(wheel.Platform, system) {
+				continue
+			}
+			if rank := interpreterRank(wheel, minor); rank > bestRank {
+				best, bestRank = i, rank
+			}
+		}
+		if best >= 0 {
+			wheel := wheels[best]
+			wheel.System = system
+			selected = append(selected, wheel)
+		}
+	}
+	return selected, nil
+}
+
+// hasTag reports whether a compressed tag set such as "py2.py3" contains tag.
+func hasTag(tags, tag string) bool {
+	for _, t := range strings.Split(tags, ".") {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// interpreterRank rates how well a wheel fits CPython 3.minor: 3 for a wheel
+// built for exactly that interpreter, 2 for the stable ABI (abi3), 1 for a
+// platform-specific py3-none wheel and 0 when it does not install. The stable
+// ABI is assumed to fit when minor is unknown (-1, the "python3" attr).
+func interpreterRank(wheel PyPIWheel, minor int) int {
+	for _, python := range strings.Split(wheel.Python, ".") {
+		switch {
+		case minor >= 0 && python == fmt.Sprintf("cp3%d", minor) && (hasTag(wheel.ABI, python) || hasTag(wheel.ABI, "none")):
+			return 3
+		case hasTag(wheel.ABI, "abi3") && strings.HasPrefix(python, "cp3"):
+			var built int
+			if _, err := fmt.Sscanf(python, "cp3%d", &built); err == nil && (minor < 0 || built <= minor) {
+				return 2
+			}
+		case python == "py3" && hasTag(wheel.ABI, "none"):
+			return 1
+		}
+	}
+	return 0
+}
+
+// platformMatches reports whether a compressed platform tag installs on a Nix
+// system. musllinux wheels do not fit the glibc-based Linux systems.
+func platformMatches(platforms, system string) bool {
+	for _, platform := range strings.Split(platforms, ".") {
+		linux := strings.HasPrefix(platform, "manylinux")
+		darwin := strings.HasPrefix(platform, "macosx_")
+		switch system {
+		case "x86_64-linux":
+			if linux && strings.HasSuffix(platform, "_x86_64") {
+				return true
+			}
+		case "aarch64-linux":
+			if linux && strings.HasSuffix(platform, "_aarch64") {
+				return true
+			}
+		case "x86_64-darwin":
+			if darwin && (strings.HasSuffix(platform, "_x86_64") || strings.HasSuffix(platform, "_intel") || strings.HasSuffix(platform, "_universal2")) {
+				return true
+			}
+		case "aarch64-darwin":
+			if darwin && (strings.HasSuffix(platform, "_arm64") || strings.HasSuffix(platform, "_universal2")) {
+				return true
+			}
+		}
+	}
+	return false
+}
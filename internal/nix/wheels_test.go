@@ -0,0 +1,71 @@
+package nix
+
+import (
+	"strings"
+	"testing"
+)
+
+// wheelFiles returns PyPI files for wheel file names, all with the same digest.
+func wheelFiles(names ...string) []pypiFile {
+	files := make([]pypiFile, len(names))
+	for i, name := range names {
+		files[i].Filename = name
+		files[i].PackageType = "bdist_wheel"
+		files[i].Digests.SHA256 = strings.Repeat("0", 64)
+	}
+	return files
+}
+
+func TestSelectWheels(t *testing.T) {
+	platform := wheelFiles(
+		"core-2.0-cp311-cp311-manylinux_2_17_x86_64.manylinux2014_x86_64.whl",
+		"core-2.0-cp312-cp312-manylinux_2_17_x86_64.manylinux2014_x86_64.whl",
+		"core-2.0-cp312-cp312-musllinux_1_1_aarch64.whl",
+		"core-2.0-cp38-abi3-manylinux_2_17_aarch64.manylinux2014_aarch64.whl",
+		"core-2.0-cp312-cp312-macosx_10_12_x86_64.whl",
+		"core-2.0-cp312-cp312-macosx_11_0_arm64.whl",
+		"core-2.0-cp312-cp312-win_amd64.whl",
+	)
+	tests := []struct {
+		name   string
+		files  []pypiFile
+		python string
+		want   []string // System: file python/abi tags, in defaultSystems order
+	}{
+		{"pure wheel", wheelFiles("pkg-1.0-py2.py3-none-any.whl", "pkg-1.0-cp312-cp312-manylinux_2_17_x86_64.whl"), "python312",
+			[]string{": py2.py3/none"}},
+		{"exact interpreter, stable ABI, no musl", platform, "python312",
+			[]string{"x86_64-linux: cp312/cp312", "aarch64-linux: cp38/abi3", "x86_64-darwin: cp312/cp312", "aarch64-darwin: cp312/cp312"}},
+		{"systems without a wheel are left out", platform, "python311",
+			[]string{"x86_64-linux: cp311/cp311", "aarch64-linux: cp38/abi3"}},
+		{"abi3 is assumed to fit python3", platform, "python3",
+			[]string{"aarch64-linux: cp38/abi3"}},
+		{"abi3 built for a newer Python", wheelFiles("core-2.0-cp313-abi3-macosx_11_0_universal2.whl"), "python312", nil},
+		{"universal2", wheelFiles("core-2.0-cp312-abi3-macosx_11_0_universal2.whl"), "python312",
+			[]string{"x86_64-darwin: cp312/abi3", "aarch64-darwin: cp312/abi3"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wheels, err := selectWheels(tt.files, tt.python)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, wheel := range wheels {
+				got = append(got, wheel.System+": "+wheel.Python+"/"+wheel.ABI)
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectWheelsSkipsYanked(t *testing.T) {
+	files := wheelFiles("pkg-1.0-py3-none-any.whl")
+	files[0].Yanked = true
+	wheels, err := selectWheels(files, "python312")
+	if err != nil || len(wheels) != 0 {
+		t.Errorf("selectWheels = %v, %v; want no wheels", wheels, err)
+	}
+}
@@ -1,7 +1,10 @@
 package tui
 
 import (
+	"context"
+
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/mmxgn/manos-nix-template-builder/internal/models"
@@ -17,11 +20,16 @@ const (
 	ScreenLanguageSelector        // Language picker (reserved for future multi-language)
 	ScreenTemplateOrCustom        // Language-specific preset templates or custom
 	ScreenNixpkgsSelector         // Nixpkgs channel selection (before Python version)
+	ScreenNixpkgsPin              // Pin the chosen channel to a revision (opened with p)
+	ScreenFlakeInputs             // Extra flake inputs (opened with i)
 	ScreenVersionSelector         // Python version selection (Custom mode only)
 	ScreenPackageSelector         // Package multi-select (Custom mode only)
 	ScreenToolSelector            // Tool multi-select (Custom mode only)
 	ScreenFeatureSelector         // Feature multi-select (Custom mode only)
+	ScreenShellHookEditor         // shellHook snippets + custom commands (Custom mode only)
+	ScreenServiceSelector         // Local services via process-compose (Custom mode only)
 	ScreenConfirmation
+	ScreenShellProfiles           // Extra named devShells, opened from the confirmation screen
 	ScreenCompletion
 )
 
@@ -43,6 +51,7 @@ type Model struct {
 	Packages []models.Package
 	Tools    []models.Package
 	Features []models.Feature
+	Snippets []models.ShellSnippet
 
 	// UI Components
 	ModeList             list.Model
@@ -59,31 +68,72 @@ type Model struct {
 	// Selection state
 	Cursor              int                    // For multi-select navigation
 	SelectedNixpkgs     models.NixpkgsChannel  // Currently chosen nixpkgs channel
+	PinRevisions        []nix.NixpkgsRevision  // Pin candidates for the highlighted channel
+	LoadingRevisions    bool                   // True while the pin candidates are looked up
+	ResolvingPinRev     bool                   // True while the date and branch of a typed revision are looked up
 	SelectedPackages    map[string]bool
 	SelectedTools       map[string]bool
 	SelectedFeatures    map[string]bool
+	FeatureParams       map[string]string // Current choice per feature parameter key
 	CustomPackages      []string        // User-entered custom nixpkgs packages
 	PyPIPackages        []string        // User-entered PyPI packages
 	SelectedPyPI        map[string]bool // Which PyPI packages are selected
 	PyPICursor          int             // Cursor within PyPI list in overlay
+	PyPIInputErr        error           // Why the requirement typed in the overlay was rejected
+	PyPITree            []nix.PyPIPackageInfo // Selected PyPI packages and their dependencies missing from nixpkgs
+	PyPITreeErr         error                 // Why resolving the dependency tree failed
+	ResolvingPyPITree   bool                  // True while the dependency tree is resolved on PyPI
 	EnvVars             []string        // User-entered environment variable names
 	SelectedEnvVars     map[string]bool // Which env vars are selected
 	EnvVarCursor        int             // Cursor within env var list in overlay
+	SelectedSnippets    map[string]bool // Which shellHook snippets are selected (by name)
+	SelectedServices    map[string]bool // Which local services are selected (by name)
+	PackageOverrides    map[string]models.PackageOverride // Saved overrides, keyed by package NixAttr
+	OverrideAttr        string                            // Package being edited in the override editor
+	OverrideDraft       models.PackageOverride            // Unsaved edits in the override editor
+	OverrideField       int                               // Focused field in the override editor
+	ProfileRowCursor    int                               // Highlighted row in the devShell profile editor
 
 	// Input modes
 	AddingCustomPackage bool // True when adding custom package inline
 	AddingPyPIPackage   bool // True when adding PyPI package inline
 	AddingCustomTool    bool // True when adding custom tool inline
 	AddingEnvVar        bool // True when adding env var inline
+	AddingShellHookLine bool // True when adding a custom shellHook command inline
+	EditingOverride     bool // True when the package override editor is open
+	AddingShellProfile  bool // True when naming a new devShell profile inline
+	EditingShellProfile bool // True when editing the packages, tools and features of a devShell profile
+	EditingFeatureParam bool // True when typing a free-text feature parameter inline
+	AddingPinRev        bool // True when typing a nixpkgs revision inline
+	AddingFlakeInput    bool // True when typing a new flake input inline
+	SearchingIndex      bool // True when fuzzy-searching the package index inline
 	AskingGitAdd        bool // True when flake.nix is untracked and we ask whether to git-add
 	AskingOverwrite     bool // True when output file already exists and we ask whether to overwrite
+	PendingIssue        *models.PackageIssue // Insecure/broken package waiting for the user to allow it
+
+	// Package index search (package and tool screens)
+	SearchResults []nix.IndexEntry // Best matches for the query, best first
+	SearchCursor  int              // Highlighted search result
 
 	// Dimensions
 	Width  int
 	Height int
 
 	// Loading state
-	Loading bool
+	Loading        bool
+	Resolving      []nix.PyPIProgress // PyPI lookups of the flake being generated
+	Cancelled      bool               // True when the last generation was cancelled with ctrl+c
+	cancelGenerate context.CancelFunc
+	generateEvents chan tea.Msg // Progress of the running generation, closed when it ends
+
+	// Custom attr validation (package and tool screens)
+	Spinner      spinner.Model
+	CheckingAttr bool           // True while nix eval validates a custom attr
+	AttrCheck    *nix.AttrCheck // Last validation shown under the custom attr prompt
+
+	// Channel discovery (runs in the background when the cache is missing or stale, or on r)
+	DiscoveringChannels bool
+	ChannelDiscoveryErr error
 }
 
 // InitialModel creates a new Model with default values
@@ -112,32 +162,49 @@ func InitialModel() Model {
 	modeList.SetFilteringEnabled(false)
 
 	// Find default nixpkgs channel
-	defaultChannel := nix.NixpkgsChannels[0]
-	for _, ch := range nix.NixpkgsChannels {
+	channels, discovery := nix.LoadChannels()
+	defaultChannel := channels[0]
+	for _, ch := range channels {
 		if ch.IsDefault {
 			defaultChannel = ch
 			break
 		}
 	}
 
+	// Default shellHook snippets start selected
+	selectedSnippets := make(map[string]bool)
+	for _, snippet := range nix.ShellHookSnippets {
+		if snippet.IsDefault {
+			selectedSnippets[snippet.Name] = true
+		}
+	}
+
 	return Model{
-		CurrentScreen:    ScreenModeSelection,
-		Config:           models.UserConfig{OutputPath: "./flake.nix", NixpkgsURL: defaultChannel.FlakeURL},
-		SelectedNixpkgs:  defaultChannel,
-		SelectedPackages: make(map[string]bool),
-		SelectedTools:    make(map[string]bool),
-		SelectedFeatures: make(map[string]bool),
-		SelectedPyPI:     make(map[string]bool),
-		SelectedEnvVars:  make(map[string]bool),
-		TextInput:        ti,
-		ModeList:         modeList,
-		Cursor:           0,
-		Loading:          false,
+		CurrentScreen:       ScreenModeSelection,
+		Config:              models.UserConfig{OutputPath: "./flake.nix", NixpkgsURL: defaultChannel.FlakeURL},
+		SelectedNixpkgs:     defaultChannel,
+		SelectedPackages:    make(map[string]bool),
+		SelectedTools:       make(map[string]bool),
+		SelectedFeatures:    make(map[string]bool),
+		SelectedPyPI:        make(map[string]bool),
+		SelectedEnvVars:     make(map[string]bool),
+		SelectedSnippets:    selectedSnippets,
+		SelectedServices:    make(map[string]bool),
+		PackageOverrides:    make(map[string]models.PackageOverride),
+		TextInput:           ti,
+		ModeList:            modeList,
+		Cursor:              0,
+		Loading:             false,
+		Spinner:             spinner.New(spinner.WithSpinner(spinner.Dot), spinner.WithStyle(InfoStyle)),
+		DiscoveringChannels: discovery == nil || discovery.Stale(),
 	}
 }
 
 // Init initializes the model
 func (m Model) Init() tea.Cmd {
+	if m.DiscoveringChannels {
+		return tea.Batch(m.Spinner.Tick, discoverChannels)
+	}
 	return nil
 }
 
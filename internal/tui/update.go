@@ -1,11 +1,15 @@
 package tui
 
 import (
+	"context"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
+	"strings"
 
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/mmxgn/manos-nix-template-builder/internal/models"
 	"github.com/mmxgn/manos-nix-template-builder/internal/nix"
@@ -17,8 +21,19 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "ctrl+c", "q":
-			// Allow quitting from any screen except during loading
-			if !m.Loading && m.CurrentScreen != ScreenCompletion {
+			// While typing (search, custom attrs, the override editor, ...) q is a character
+			if msg.String() == "q" && m.TextInput.Focused() {
+				break
+			}
+			// During loading, ctrl+c cancels the generation instead of quitting
+			if m.Loading {
+				if msg.String() == "ctrl+c" {
+					return m.cancelGeneration(), nil
+				}
+				return m, nil
+			}
+			// Allow quitting from any screen
+			if m.CurrentScreen != ScreenCompletion {
 				m.Quitting = true
 				return m, tea.Quit
 			}
@@ -29,21 +44,36 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 		case "esc":
+			if m.Loading {
+				return m, nil
+			}
 			// If in input mode, cancel it instead of going back
+			if m.PendingIssue != nil {
+				m.PendingIssue = nil
+				return m, nil
+			}
 			if m.AddingCustomPackage {
 				m.AddingCustomPackage = false
+				m.CheckingAttr = false
+				m.AttrCheck = nil
 				m.TextInput.SetValue("")
 				m.TextInput.Blur()
 				return m, nil
 			}
 			if m.AddingPyPIPackage {
 				m.AddingPyPIPackage = false
+				m.PyPIInputErr = nil
+				m.ResolvingPyPITree = false
+				m.PyPITree = nil
+				m.PyPITreeErr = nil
 				m.TextInput.SetValue("")
 				m.TextInput.Blur()
 				return m, nil
 			}
 			if m.AddingCustomTool {
 				m.AddingCustomTool = false
+				m.CheckingAttr = false
+				m.AttrCheck = nil
 				m.TextInput.SetValue("")
 				m.TextInput.Blur()
 				return m, nil
@@ -54,12 +84,122 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.TextInput.Blur()
 				return m, nil
 			}
+			if m.AddingShellHookLine {
+				m.AddingShellHookLine = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+			if m.EditingOverride {
+				m.EditingOverride = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+			if m.AddingShellProfile {
+				m.AddingShellProfile = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+			if m.EditingShellProfile {
+				m.EditingShellProfile = false
+				return m, nil
+			}
+			if m.EditingFeatureParam {
+				m.EditingFeatureParam = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+			if m.AddingPinRev {
+				m.AddingPinRev = false
+				m.ResolvingPinRev = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+			if m.AddingFlakeInput {
+				m.AddingFlakeInput = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+			if m.SearchingIndex {
+				m.SearchingIndex = false
+				m.SearchResults = nil
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
 			// Go back to previous screen (except from first screen)
 			if m.CurrentScreen > ScreenModeSelection {
 				return m.goBack(), nil
 			}
 		}
 
+	case spinner.TickMsg:
+		if !m.CheckingAttr && !m.DiscoveringChannels && !m.Loading && !m.ResolvingPyPITree && !m.LoadingRevisions && !m.ResolvingPinRev {
+			return m, nil
+		}
+		var cmd tea.Cmd
+		m.Spinner, cmd = m.Spinner.Update(msg)
+		return m, cmd
+
+	case attrCheckedMsg:
+		// Dropped when the prompt was cancelled while nix eval ran
+		if !m.CheckingAttr {
+			return m, nil
+		}
+		return m.applyAttrCheck(nix.AttrCheck(msg)), nil
+
+	case channelsDiscoveredMsg:
+		return m.applyChannels(msg.err), nil
+
+	case revisionsMsg:
+		// Dropped when the pin screen was left (or opened for another channel) meanwhile
+		if !m.LoadingRevisions || msg.channelURL != m.SelectedNixpkgs.FlakeURL {
+			return m, nil
+		}
+		m.LoadingRevisions = false
+		m.PinRevisions = msg.revisions
+		m.Cursor = 0
+		return m, nil
+
+	case pinnedRevisionMsg:
+		// Dropped when the prompt was cancelled while nix looked the revision up
+		if !m.ResolvingPinRev {
+			return m, nil
+		}
+		m.ResolvingPinRev = false
+		m.AddingPinRev = false
+		m.TextInput.SetValue("")
+		m.TextInput.Blur()
+		return m.selectChannel(m.SelectedNixpkgs, nix.NixpkgsRevision(msg)), nil
+
+	case pypiTreeMsg:
+		// Dropped when the overlay was closed while resolving
+		if !m.ResolvingPyPITree {
+			return m, nil
+		}
+		m.ResolvingPyPITree = false
+		m.PyPITree, m.PyPITreeErr = msg.packages, msg.err
+		return m, nil
+
+	case pypiProgressMsg:
+		// Dropped when it belongs to a cancelled generation
+		if msg.events != m.generateEvents {
+			return m, nil
+		}
+		m = m.applyPyPIProgress(msg.progress)
+		return m, waitForGenerate(m.generateEvents)
+
+	case flakeGeneratedMsg:
+		if msg.events != m.generateEvents {
+			return m, nil
+		}
+		return m.completeGeneration(msg.content, msg.err), nil
+
 	case tea.WindowSizeMsg:
 		m.Width = msg.Width
 		m.Height = msg.Height
@@ -112,6 +252,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updateTemplateOrCustom(msg)
 	case ScreenNixpkgsSelector:
 		return m.updateNixpkgsSelector(msg)
+	case ScreenNixpkgsPin:
+		return m.updateNixpkgsPin(msg)
+	case ScreenFlakeInputs:
+		return m.updateFlakeInputs(msg)
 	case ScreenVersionSelector:
 		return m.updateVersionSelector(msg)
 	case ScreenPackageSelector:
@@ -120,8 +264,14 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m.updateToolSelector(msg)
 	case ScreenFeatureSelector:
 		return m.updateFeatureSelector(msg)
+	case ScreenShellHookEditor:
+		return m.updateShellHookEditor(msg)
+	case ScreenServiceSelector:
+		return m.updateServiceSelector(msg)
 	case ScreenConfirmation:
 		return m.updateConfirmation(msg)
+	case ScreenShellProfiles:
+		return m.updateShellProfiles(msg)
 	case ScreenCompletion:
 		return m.updateCompletion(msg)
 	}
@@ -131,6 +281,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 // goBack navigates to the previous screen
 func (m Model) goBack() Model {
+	m.Cancelled = false
 	switch m.CurrentScreen {
 	case ScreenTemplateBrowser:
 		m.CurrentScreen = ScreenModeSelection
@@ -140,6 +291,11 @@ func (m Model) goBack() Model {
 		m.CurrentScreen = ScreenModeSelection
 	case ScreenNixpkgsSelector:
 		m.CurrentScreen = ScreenTemplateOrCustom
+	case ScreenNixpkgsPin:
+		m.LoadingRevisions = false
+		m.CurrentScreen = ScreenNixpkgsSelector
+	case ScreenFlakeInputs:
+		m.CurrentScreen = ScreenNixpkgsSelector
 	case ScreenVersionSelector:
 		m.CurrentScreen = ScreenNixpkgsSelector
 	case ScreenPackageSelector:
@@ -148,18 +304,26 @@ func (m Model) goBack() Model {
 		m.CurrentScreen = ScreenPackageSelector
 	case ScreenFeatureSelector:
 		m.CurrentScreen = ScreenToolSelector
+	case ScreenServiceSelector:
+		m.CurrentScreen = ScreenShellHookEditor
+	case ScreenShellHookEditor:
+		if len(m.Features) > 0 {
+			m.CurrentScreen = ScreenFeatureSelector
+		} else {
+			m.CurrentScreen = ScreenToolSelector
+		}
 	case ScreenConfirmation:
 		if m.Config.Mode == "quick" {
 			m.CurrentScreen = ScreenTemplateBrowser
 		} else {
 			if m.Config.TemplateName != "" {
 				m.CurrentScreen = ScreenTemplateOrCustom
-			} else if len(m.Features) > 0 {
-				m.CurrentScreen = ScreenFeatureSelector
 			} else {
-				m.CurrentScreen = ScreenToolSelector
+				m.CurrentScreen = ScreenServiceSelector
 			}
 		}
+	case ScreenShellProfiles:
+		m.CurrentScreen = ScreenConfirmation
 	case ScreenCompletion:
 		// Can't go back from completion
 	}
@@ -204,9 +368,10 @@ func (m Model) updateModeSelection(msg tea.Msg) (tea.Model, tea.Cmd) {
 					// Skip language selection - only Python is supported
 					m.Config.Language = "python"
 					langDef, _ := nix.GetLanguage("python")
-					m.Versions = langDef.AvailableVersions
+					m.Versions = nix.PythonVersions(nix.Channels())
 					m.Packages = langDef.CommonPackages
 					m.Features = langDef.SpecialFeatures
+					m.Snippets = nix.ShellHookSnippets
 					m.LangTemplates = langDef.AvailableTemplates
 
 					// Setup template/custom selection list
@@ -266,19 +431,8 @@ func (m Model) updateTemplateOrCustom(msg tea.Msg) (tea.Model, tea.Cmd) {
 					if tmpl.Name == item.ItemTitle {
 						if tmpl.Name == "Custom" {
 							// Go to custom mode - nixpkgs channel selection first
-							items := make([]list.Item, len(nix.NixpkgsChannels))
-							for i, ch := range nix.NixpkgsChannels {
-								desc := ch.FlakeURL
-								if ch.IsDefault {
-									desc += " (default)"
-								}
-								items[i] = ListItem{
-									ItemTitle: ch.Name,
-									ItemDesc:  desc,
-								}
-							}
 							delegate := list.NewDefaultDelegate()
-							m.NixpkgsChannelList = list.New(items, delegate, m.Width-4, m.Height-10)
+							m.NixpkgsChannelList = list.New(channelItems(), delegate, m.Width-4, m.Height-10)
 							m.NixpkgsChannelList.Title = "Select nixpkgs Channel"
 							m.NixpkgsChannelList.SetShowStatusBar(false)
 							m.NixpkgsChannelList.SetFilteringEnabled(false)
@@ -294,18 +448,22 @@ func (m Model) updateTemplateOrCustom(msg tea.Msg) (tea.Model, tea.Cmd) {
 							m.Config.Packages = tmpl.Packages
 							m.Config.Tools = tmpl.Tools
 							m.Config.NixpkgsURL = "github:NixOS/nixpkgs/nixos-unstable"
+							m.Config.NixpkgsRev = ""
+							m.Config.NixpkgsRevDate = ""
+							for _, ch := range nix.Channels() {
+								if ch.FlakeURL == m.Config.NixpkgsURL {
+									m.SelectedNixpkgs = ch
+								}
+							}
+							m.Config.ShellHook = nix.DefaultShellHook()
 
-							// Enable features by converting feature names to NixAttrs
-							m.Config.EnabledFeatures = make([]string, 0)
+							// Enable the preset's features with default parameters
+							m.SelectedFeatures = make(map[string]bool)
 							for _, featureName := range tmpl.Features {
-								// Find feature and add its NixAttrs
-								for _, feature := range m.Features {
-									if feature.Name == featureName {
-										m.Config.EnabledFeatures = append(m.Config.EnabledFeatures, feature.NixAttrs...)
-										break
-									}
-								}
+								m.SelectedFeatures[featureName] = true
 							}
+							m.FeatureParams = nix.DefaultFeatureParams(m.Features, m.SelectedNixpkgs)
+							m = m.applyFeatures()
 
 							// Go directly to confirmation
 							m.CurrentScreen = ScreenConfirmation
@@ -328,18 +486,36 @@ func (m Model) updateNixpkgsSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.KeyMsg:
 		switch msg.String() {
 		case "enter":
-			selected := m.NixpkgsChannelList.SelectedItem()
-			if item, ok := selected.(ListItem); ok {
-				for _, ch := range nix.NixpkgsChannels {
-					if ch.Name == item.ItemTitle {
-						m.SelectedNixpkgs = ch
-						m.Config.NixpkgsURL = ch.FlakeURL
-						// Reset cursor for version selection
-						m.Cursor = 0
-						m.CurrentScreen = ScreenVersionSelector
-						return m, nil
+			if ch, ok := m.highlightedChannel(); ok {
+				return m.selectChannel(ch, nix.NixpkgsRevision{}), nil
+			}
+		case "i":
+			m.Cursor = 0
+			m.CurrentScreen = ScreenFlakeInputs
+			return m, nil
+		case "r":
+			// Rediscover the channels with nix eval in the background
+			if !m.DiscoveringChannels {
+				m.DiscoveringChannels = true
+				m.ChannelDiscoveryErr = nil
+				return m, tea.Batch(m.Spinner.Tick, discoverChannels)
+			}
+			return m, nil
+		case "p":
+			// Pin: look up candidate revisions of the highlighted channel in the background
+			if ch, ok := m.highlightedChannel(); ok {
+				m.SelectedNixpkgs = ch
+				m.PinRevisions = nil
+				m.LoadingRevisions = true
+				m.Cursor = 0
+				m.CurrentScreen = ScreenNixpkgsPin
+				lockPath := filepath.Join(filepath.Dir(m.Config.OutputPath), "flake.lock")
+				return m, tea.Batch(m.Spinner.Tick, func() tea.Msg {
+					return revisionsMsg{
+						channelURL: ch.FlakeURL,
+						revisions:  nix.DiscoverRevisions(context.Background(), ch.FlakeURL, lockPath),
 					}
-				}
+				})
 			}
 		}
 	}
@@ -349,6 +525,195 @@ func (m Model) updateNixpkgsSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, cmd
 }
 
+// highlightedChannel returns the channel under the cursor of the channel list.
+func (m Model) highlightedChannel() (models.NixpkgsChannel, bool) {
+	if item, ok := m.NixpkgsChannelList.SelectedItem().(ListItem); ok {
+		for _, ch := range nix.Channels() {
+			if ch.Name == item.ItemTitle {
+				return ch, true
+			}
+		}
+	}
+	return models.NixpkgsChannel{}, false
+}
+
+// selectChannel uses ch for the flake, pinned to rev unless rev.Rev is empty,
+// and moves on to version selection.
+func (m Model) selectChannel(ch models.NixpkgsChannel, rev nix.NixpkgsRevision) Model {
+	m.SelectedNixpkgs = ch
+	m.Config.NixpkgsURL = ch.FlakeURL
+	m.Config.NixpkgsRev = rev.Rev
+	m.Config.NixpkgsRevDate = rev.Date
+	m.Config.NixpkgsRevBranch = rev.Branch
+	m.FeatureParams = nix.DefaultFeatureParams(m.Features, ch)
+	// Reset cursor for version selection
+	m.Cursor = 0
+	m.CurrentScreen = ScreenVersionSelector
+	return m
+}
+
+// updateFlakeInputs manages the extra flake inputs
+func (m Model) updateFlakeInputs(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	// Handle text input when typing "name url [follows]"
+	if m.AddingFlakeInput {
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "enter":
+				input, err := nix.ParseFlakeInput(m.TextInput.Value(), m.Config.Inputs)
+				if err != nil {
+					return m, nil
+				}
+				m.Config.Inputs = append(m.Config.Inputs, input)
+				m.Cursor = len(m.Config.Inputs) - 1
+				m.AddingFlakeInput = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			case "esc":
+				m.AddingFlakeInput = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+		}
+		m.TextInput, cmd = m.TextInput.Update(msg)
+		return m, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if m.Cursor > 0 {
+				m.Cursor--
+			}
+		case "down", "j":
+			if m.Cursor < len(m.Config.Inputs)-1 {
+				m.Cursor++
+			}
+		case "a": // Add an input
+			m.AddingFlakeInput = true
+			m.TextInput.SetValue("")
+			m.TextInput.Focus()
+			return m, nil
+		case "d": // Delete the input under the cursor; its packages go back to nixpkgs
+			if m.Cursor >= 0 && m.Cursor < len(m.Config.Inputs) {
+				name := m.Config.Inputs[m.Cursor].Name
+				inputs := make([]models.FlakeInput, 0, len(m.Config.Inputs)-1)
+				inputs = append(inputs, m.Config.Inputs[:m.Cursor]...)
+				m.Config.Inputs = append(inputs, m.Config.Inputs[m.Cursor+1:]...)
+				for attr, source := range m.Config.PackageSources {
+					if source == name {
+						delete(m.Config.PackageSources, attr)
+					}
+				}
+				if m.Cursor > 0 && m.Cursor >= len(m.Config.Inputs) {
+					m.Cursor--
+				}
+			}
+		case "enter":
+			m.CurrentScreen = ScreenNixpkgsSelector
+			return m, nil
+		}
+	}
+	return m, nil
+}
+
+// cycleSource moves attr to the next input it can come from: nixpkgs, then
+// each extra input in order. Python packages skip nixpkgs inputs, whose
+// packages are built for that input's interpreter, not the environment's.
+func (m Model) cycleSource(attr string, python bool) Model {
+	var inputs []models.FlakeInput
+	for _, input := range m.Config.Inputs {
+		if !python || !nix.IsNixpkgsURL(input.URL) {
+			inputs = append(inputs, input)
+		}
+	}
+	if len(inputs) == 0 {
+		return m
+	}
+	if m.Config.PackageSources == nil {
+		m.Config.PackageSources = make(map[string]string)
+	}
+	current := m.Config.PackageSources[attr]
+	next := inputs[0].Name
+	for i, input := range inputs {
+		if input.Name == current {
+			next = ""
+			if i+1 < len(inputs) {
+				next = inputs[i+1].Name
+			}
+		}
+	}
+	if next == "" {
+		delete(m.Config.PackageSources, attr)
+	} else {
+		m.Config.PackageSources[attr] = next
+	}
+	return m
+}
+
+// updateNixpkgsPin handles choosing or typing the revision to pin nixpkgs to
+func (m Model) updateNixpkgsPin(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	// Handle text input when typing a revision
+	if m.AddingPinRev {
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "enter":
+				rev := strings.ToLower(strings.TrimSpace(m.TextInput.Value()))
+				if !nix.ValidRev(rev) || m.ResolvingPinRev {
+					return m, nil
+				}
+				m.ResolvingPinRev = true
+				return m, tea.Batch(m.Spinner.Tick, resolvePinnedRevision(m.SelectedNixpkgs.FlakeURL, rev))
+			case "esc":
+				m.AddingPinRev = false
+				m.ResolvingPinRev = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+		}
+		if m.ResolvingPinRev {
+			return m, nil
+		}
+		m.TextInput, cmd = m.TextInput.Update(msg)
+		return m, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if m.Cursor > 0 {
+				m.Cursor--
+			}
+		case "down", "j":
+			if m.Cursor < len(m.PinRevisions)-1 {
+				m.Cursor++
+			}
+		case "c": // Type a revision by hand
+			m.AddingPinRev = true
+			m.TextInput.SetValue("")
+			m.TextInput.Focus()
+			return m, nil
+		case "u": // Follow the branch instead
+			return m.selectChannel(m.SelectedNixpkgs, nix.NixpkgsRevision{}), nil
+		case "enter":
+			if m.Cursor >= 0 && m.Cursor < len(m.PinRevisions) {
+				return m.selectChannel(m.SelectedNixpkgs, m.PinRevisions[m.Cursor]), nil
+			}
+		}
+	}
+	return m, nil
+}
+
 func (m Model) updateLanguageSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
@@ -360,9 +725,10 @@ func (m Model) updateLanguageSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 				for key, langDef := range nix.LanguageDefinitions {
 					if langDef.Name == item.ItemTitle {
 						m.Config.Language = key
-						m.Versions = langDef.AvailableVersions
+						m.Versions = nix.PythonVersions(nix.Channels())
 						m.Packages = langDef.CommonPackages
 						m.Features = langDef.SpecialFeatures
+						m.Snippets = nix.ShellHookSnippets
 						m.LangTemplates = langDef.AvailableTemplates
 
 						// Setup template/custom selection list
@@ -438,30 +804,29 @@ func (m Model) isVersionSupported(nixAttr string) bool {
 func (m Model) updatePackageSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 
+	if m.PendingIssue != nil {
+		return m.updateIssuePrompt(msg, m.SelectedPackages)
+	}
+	if m.SearchingIndex {
+		return m.updateIndexSearch(msg, true)
+	}
+
 	// Handle text input when adding custom package
 	if m.AddingCustomPackage {
 		switch msg := msg.(type) {
 		case tea.KeyMsg:
 			switch msg.String() {
 			case "enter":
-				// Add custom package if input is not empty
-				value := m.TextInput.Value()
-				if value != "" {
-					// Add to packages list
-					newPkg := models.Package{
-						Name:     value,
-						NixAttr:  value,
-						Description: "Custom package",
-					}
-					m.Packages = append(m.Packages, newPkg)
-					// Mark as selected
-					m.SelectedPackages[value] = true
-					m.TextInput.SetValue("")
-				}
+				// Validate, then add the custom package if input is not empty
+				return m.submitCustomAttr(true)
+			case "tab":
+				m = m.useSuggestion()
 				return m, nil
 			case "esc":
 				// Cancel adding custom package
 				m.AddingCustomPackage = false
+				m.CheckingAttr = false
+				m.AttrCheck = nil
 				m.TextInput.SetValue("")
 				m.TextInput.Blur()
 				return m, nil
@@ -491,11 +856,26 @@ func (m Model) updatePackageSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.TextInput.Value() == "" && len(m.PyPIPackages) > 0 {
 					pkg := m.PyPIPackages[m.PyPICursor]
 					m.SelectedPyPI[pkg] = !m.SelectedPyPI[pkg]
+					m.PyPITree = nil
+					m.PyPITreeErr = nil
+				}
+				return m, nil
+			case "ctrl+t":
+				// Show the dependency tree (only when input empty)
+				if m.TextInput.Value() == "" {
+					return m.resolvePyPITree()
 				}
 				return m, nil
 			case "enter":
-				value := m.TextInput.Value()
+				value := strings.TrimSpace(m.TextInput.Value())
 				if value != "" {
+					if _, err := nix.ParseRequirement(value); err != nil {
+						m.PyPIInputErr = err
+						return m, nil
+					}
+					m.PyPIInputErr = nil
+					m.PyPITree = nil
+					m.PyPITreeErr = nil
 					m.PyPIPackages = append(m.PyPIPackages, value)
 					m.SelectedPyPI[value] = true
 					m.PyPICursor = len(m.PyPIPackages) - 1
@@ -508,15 +888,22 @@ func (m Model) updatePackageSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, nil
 			case "esc":
 				m.AddingPyPIPackage = false
+				m.PyPIInputErr = nil
 				m.TextInput.SetValue("")
 				m.TextInput.Blur()
 				return m, nil
 			}
 		}
+		m.PyPIInputErr = nil
 		m.TextInput, cmd = m.TextInput.Update(msg)
 		return m, cmd
 	}
 
+	// Handle package override editor
+	if m.EditingOverride {
+		return m.updateOverrideEditor(msg)
+	}
+
 	// Handle env var overlay: cursor navigation + text input to add more
 	if m.AddingEnvVar {
 		switch msg := msg.(type) {
@@ -583,17 +970,30 @@ func (m Model) updatePackageSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		case " ": // Spacebar toggles selection
 			if m.Cursor >= 0 && m.Cursor < len(m.Packages) {
-				pkg := m.Packages[m.Cursor]
-				m.SelectedPackages[pkg.NixAttr] = !m.SelectedPackages[pkg.NixAttr]
+				m = m.toggleItem(m.Packages[m.Cursor], true)
 			}
-		case "a": // Select all
+		case "a": // Select all (flagged packages need to be allowed one by one)
 			for _, pkg := range m.Packages {
-				m.SelectedPackages[pkg.NixAttr] = true
+				if m.selectable(pkg) {
+					m.SelectedPackages[pkg.NixAttr] = true
+				}
+			}
+		case "M": // Migrate selected packages the channel renamed
+			for _, pkg := range m.renamedSelections(m.Packages, m.SelectedPackages) {
+				m = m.migrateAttr(pkg, true)
 			}
 		case "n": // Select none
 			m.SelectedPackages = make(map[string]bool)
+		case "/": // Fuzzy-search the package index
+			m.SearchingIndex = true
+			m.SearchResults = nil
+			m.SearchCursor = 0
+			m.TextInput.SetValue("")
+			m.TextInput.Focus()
+			return m, nil
 		case "c": // Add custom nixpkg package
 			m.AddingCustomPackage = true
+			m.AttrCheck = nil
 			m.TextInput.SetValue("")
 			m.TextInput.Focus()
 			return m, nil
@@ -606,6 +1006,20 @@ func (m Model) updatePackageSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.TextInput.SetValue("")
 			m.TextInput.Focus()
 			return m, nil
+		case "i": // Cycle the input the package under the cursor comes from
+			if m.Cursor >= 0 && m.Cursor < len(m.Packages) {
+				m = m.cycleSource(m.Packages[m.Cursor].NixAttr, true)
+			}
+		case "o": // Open override editor for the package under the cursor
+			if m.Cursor >= 0 && m.Cursor < len(m.Packages) {
+				m.EditingOverride = true
+				m.OverrideAttr = m.Packages[m.Cursor].NixAttr
+				m.OverrideDraft = m.PackageOverrides[m.OverrideAttr]
+				m.OverrideField = overrideFieldVersion
+				m.TextInput.SetValue(overrideFieldValue(m.OverrideDraft, m.OverrideField))
+				m.TextInput.Focus()
+			}
+			return m, nil
 		case "e": // Open env var overlay
 			m.AddingEnvVar = true
 			m.EnvVarCursor = len(m.EnvVars) - 1
@@ -623,6 +1037,13 @@ func (m Model) updatePackageSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.Config.Packages = append(m.Config.Packages, pkg.NixAttr)
 				}
 			}
+			// Collect overrides of selected packages
+			m.Config.PackageOverrides = make(map[string]models.PackageOverride)
+			for _, attr := range m.Config.Packages {
+				if override, ok := m.PackageOverrides[attr]; ok {
+					m.Config.PackageOverrides[attr] = override
+				}
+			}
 			// Collect only selected PyPI packages
 			m.Config.PyPIPackages = make([]string, 0)
 			for _, pkg := range m.PyPIPackages {
@@ -647,52 +1068,490 @@ func (m Model) updatePackageSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-// updateToolSelector handles multi-select tool selection
-func (m Model) updateToolSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
-	var cmd tea.Cmd
+// channelsDiscoveredMsg reports the end of background channel discovery
+type channelsDiscoveredMsg struct{ err error }
 
-	// Handle text input when adding custom tool
-	if m.AddingCustomTool {
-		switch msg := msg.(type) {
-		case tea.KeyMsg:
-			switch msg.String() {
-			case "enter":
-				value := m.TextInput.Value()
-				if value != "" {
-					newTool := models.Package{
-						Name:        value,
-						NixAttr:     value,
-						Description: "Custom tool",
-					}
-					m.Tools = append(m.Tools, newTool)
-					m.SelectedTools[value] = true
-					m.TextInput.SetValue("")
-				}
-				return m, nil
-			case "esc":
-				m.AddingCustomTool = false
-				m.TextInput.SetValue("")
-				m.TextInput.Blur()
-				return m, nil
-			}
+// discoverChannels rediscovers the nixpkgs channels and their Python versions.
+func discoverChannels() tea.Msg {
+	_, err := nix.DiscoverChannels(context.Background())
+	return channelsDiscoveredMsg{err: err}
+}
+
+// channelItems returns the channel list items of the current channels.
+func channelItems() []list.Item {
+	channels := nix.Channels()
+	items := make([]list.Item, len(channels))
+	for i, ch := range channels {
+		desc := ch.FlakeURL
+		if ch.IsDefault {
+			desc += " (default)"
+		}
+		items[i] = ListItem{
+			ItemTitle: ch.Name,
+			ItemDesc:  desc,
 		}
-		m.TextInput, cmd = m.TextInput.Update(msg)
-		return m, cmd
 	}
+	return items
+}
 
-	switch msg := msg.(type) {
-	case tea.KeyMsg:
-		switch msg.String() {
-		case "up", "k":
-			if m.Cursor > 0 {
-				m.Cursor--
-			}
-		case "down", "j":
-			if m.Cursor < len(m.Tools)-1 {
-				m.Cursor++
-			}
-		case "left", "h":
-			if step := m.columnStep(m.Tools); step > 0 && m.Cursor >= step {
+// applyChannels switches to the discovered channels: the channel list, the
+// version list and the selected channel are rebuilt from them. On failure the
+// cached or built-in channels stay in use.
+func (m Model) applyChannels(err error) Model {
+	m.DiscoveringChannels = false
+	m.ChannelDiscoveryErr = err
+	if err != nil {
+		return m
+	}
+	if len(m.NixpkgsChannelList.Items()) > 0 {
+		m.NixpkgsChannelList.SetItems(channelItems())
+	}
+	if m.Versions != nil {
+		m.Versions = nix.PythonVersions(nix.Channels())
+	}
+	for _, ch := range nix.Channels() {
+		if ch.FlakeURL == m.Config.NixpkgsURL {
+			m.SelectedNixpkgs = ch
+		}
+	}
+	return m
+}
+
+// pypiTreeMsg carries the selected PyPI packages with their dependencies,
+// or why resolving them failed
+type pypiTreeMsg struct {
+	packages []nix.PyPIPackageInfo
+	err      error
+}
+
+// resolvePyPITree resolves the selected PyPI packages, and recursively their
+// dependencies missing from nixpkgs, in the background behind a spinner.
+func (m Model) resolvePyPITree() (tea.Model, tea.Cmd) {
+	var requirements []string
+	for _, pkg := range m.PyPIPackages {
+		if m.SelectedPyPI[pkg] {
+			requirements = append(requirements, pkg)
+		}
+	}
+	if len(requirements) == 0 || m.ResolvingPyPITree {
+		return m, nil
+	}
+	m.ResolvingPyPITree = true
+	m.PyPITree = nil
+	m.PyPITreeErr = nil
+	config := m.Config
+	return m, tea.Batch(m.Spinner.Tick, func() tea.Msg {
+		packages, err := nix.ResolvePyPIPackages(context.Background(), requirements, config, nil)
+		return pypiTreeMsg{packages, err}
+	})
+}
+
+// attrCheckedMsg carries the result of validating a custom attr with nix eval
+type attrCheckedMsg nix.AttrCheck
+
+// revisionsMsg carries the pin candidates discovered for a channel
+type revisionsMsg struct {
+	channelURL string
+	revisions  []nix.NixpkgsRevision
+}
+
+// pinnedRevisionMsg carries a typed revision with its date, when nix could look it up
+type pinnedRevisionMsg nix.NixpkgsRevision
+
+// resolvePinnedRevision looks up the date of a typed revision in the background.
+// The date is only for the comment; without nix or network it stays unknown.
+// The branch stays unknown too, since a commit can be on several branches.
+func resolvePinnedRevision(channelURL, rev string) tea.Cmd {
+	return func() tea.Msg {
+		pinned, err := nix.FetchRevision(context.Background(), nix.PinnedURL(channelURL, rev))
+		if err != nil || pinned.Rev != rev {
+			pinned = nix.NixpkgsRevision{Rev: rev}
+		}
+		return pinnedRevisionMsg(pinned)
+	}
+}
+
+// submitCustomAttr validates the typed custom package (python) or tool and adds
+// it when the channel has it. The package index decides when it can; otherwise
+// nix eval runs in the background behind a spinner. Submitting a name that was
+// just flagged as unknown adds it anyway.
+func (m Model) submitCustomAttr(python bool) (tea.Model, tea.Cmd) {
+	value := strings.TrimSpace(m.TextInput.Value())
+	if value == "" || m.CheckingAttr {
+		return m, nil
+	}
+	if m.AttrCheck != nil && m.AttrCheck.Attr == value && !m.AttrCheck.Known {
+		return m.addCustomAttr(value, python), nil
+	}
+	if check, ok := nix.CheckAttrIndex(m.Config.NixpkgsURL, value, python); ok {
+		return m.applyAttrCheck(check), nil
+	}
+
+	m.CheckingAttr = true
+	m.AttrCheck = nil
+	config := m.Config
+	return m, tea.Batch(m.Spinner.Tick, func() tea.Msg {
+		return attrCheckedMsg(nix.CheckAttrEval(context.Background(), config, value, python))
+	})
+}
+
+// applyAttrCheck adds a known (or uncheckable) custom attr, or keeps the prompt
+// open with the "did you mean" suggestions for an unknown one.
+func (m Model) applyAttrCheck(check nix.AttrCheck) Model {
+	m.CheckingAttr = false
+	m.AttrCheck = &check
+	if check.Known || check.Unchecked {
+		m = m.addCustomAttr(check.Attr, check.Python)
+		if check.Known {
+			m.AttrCheck = nil
+		}
+	}
+	return m
+}
+
+// addCustomAttr appends a custom package (python) or tool to its list and selects it.
+func (m Model) addCustomAttr(attr string, python bool) Model {
+	listed := func(pkg models.Package) bool { return pkg.NixAttr == attr }
+	if python {
+		if !slices.ContainsFunc(m.Packages, listed) {
+			m.Packages = append(m.Packages, models.Package{Name: attr, NixAttr: attr, Description: "Custom package"})
+		}
+		m = m.selectChecked(attr, m.SelectedPackages)
+	} else {
+		if !slices.ContainsFunc(m.Tools, listed) {
+			m.Tools = append(m.Tools, models.Package{Name: attr, NixAttr: attr, Description: "Custom tool"})
+		}
+		m = m.selectChecked(attr, m.SelectedTools)
+	}
+	m.TextInput.SetValue("")
+	return m
+}
+
+// useSuggestion replaces the typed attr with the first "did you mean" suggestion.
+func (m Model) useSuggestion() Model {
+	if m.AttrCheck != nil && len(m.AttrCheck.Suggestions) > 0 {
+		m.TextInput.SetValue(m.AttrCheck.Suggestions[0])
+		m.TextInput.CursorEnd()
+		m.AttrCheck = nil
+	}
+	return m
+}
+
+// indexSearchLimit is how many package index matches the search shows
+const indexSearchLimit = 10
+
+// updateIndexSearch handles the fuzzy search over the package index: python
+// searches the Python package set (package screen), otherwise top-level
+// attrs (tool screen). Enter adds the highlighted match to the list and selects it.
+func (m Model) updateIndexSearch(msg tea.Msg, python bool) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "up":
+			if m.SearchCursor > 0 {
+				m.SearchCursor--
+			}
+			return m, nil
+		case "down":
+			if m.SearchCursor < len(m.SearchResults)-1 {
+				m.SearchCursor++
+			}
+			return m, nil
+		case "enter":
+			if m.SearchCursor < len(m.SearchResults) {
+				m = m.addIndexEntry(m.SearchResults[m.SearchCursor], python)
+			}
+			m.SearchingIndex = false
+			m.SearchResults = nil
+			m.TextInput.SetValue("")
+			m.TextInput.Blur()
+			return m, nil
+		}
+	}
+	m.TextInput, cmd = m.TextInput.Update(msg)
+	m.SearchResults = nix.LoadIndex(m.Config.NixpkgsURL).Search(m.TextInput.Value(), python, indexSearchLimit)
+	if m.SearchCursor >= len(m.SearchResults) {
+		m.SearchCursor = max(len(m.SearchResults)-1, 0)
+	}
+	return m, cmd
+}
+
+// addIndexEntry adds a package index match to the package or tool list (unless
+// listed already), moves the cursor to it and selects it.
+func (m Model) addIndexEntry(entry nix.IndexEntry, python bool) Model {
+	items, selections := m.Tools, m.SelectedTools
+	if python {
+		items, selections = m.Packages, m.SelectedPackages
+	}
+	idx := slices.IndexFunc(items, func(pkg models.Package) bool { return pkg.NixAttr == entry.Attr })
+	if idx < 0 {
+		description := entry.Description
+		if description == "" {
+			description = "From the package index"
+		}
+		if entry.Version != "" {
+			description += " (" + entry.Version + ")"
+		}
+		items = append(items, models.Package{Name: entry.Attr, NixAttr: entry.Attr, Description: description})
+		idx = len(items) - 1
+	}
+	if python {
+		m.Packages = items
+	} else {
+		m.Tools = items
+	}
+	m.Cursor = idx
+	return m.selectChecked(entry.Attr, selections)
+}
+
+// selectChecked selects attr, or asks first when the channel marks it
+// insecure or broken.
+func (m Model) selectChecked(attr string, selections map[string]bool) Model {
+	if issue, ok := nix.ChannelIssue(m.Config.NixpkgsURL, attr); ok {
+		m.PendingIssue = &issue
+		return m
+	}
+	selections[attr] = true
+	return m
+}
+
+// selections returns the selection map of the package (python) or tool list.
+func (m Model) selections(python bool) map[string]bool {
+	if python {
+		return m.SelectedPackages
+	}
+	return m.SelectedTools
+}
+
+// toggleItem toggles a package (python) or tool. Items the channel lacks
+// cannot be selected; renamed ones select their new attr instead.
+func (m Model) toggleItem(item models.Package, python bool) Model {
+	selections := m.selections(python)
+	if selections[item.NixAttr] {
+		selections[item.NixAttr] = false
+		return m
+	}
+	if availability := nix.PackageAvailability(m.Config.NixpkgsURL, item); !availability.Available {
+		if availability.RenamedTo != "" {
+			m = m.migrateAttr(item, python)
+		}
+		return m
+	}
+	return m.selectChecked(item.NixAttr, selections)
+}
+
+// selectable reports whether "a" selects item: the channel has it and it is
+// not flagged insecure or broken.
+func (m Model) selectable(item models.Package) bool {
+	if _, flagged := nix.ChannelIssue(m.Config.NixpkgsURL, item.NixAttr); flagged {
+		return false
+	}
+	return nix.PackageAvailability(m.Config.NixpkgsURL, item).Available
+}
+
+// renamedSelections returns the selected items the channel has under a new attr.
+func (m Model) renamedSelections(items []models.Package, selections map[string]bool) []models.Package {
+	var renamed []models.Package
+	for _, item := range items {
+		if selections[item.NixAttr] && nix.PackageAvailability(m.Config.NixpkgsURL, item).RenamedTo != "" {
+			renamed = append(renamed, item)
+		}
+	}
+	return renamed
+}
+
+// migrateAttr replaces a renamed package (python) or tool by its new attr,
+// carrying over its input source and overrides.
+func (m Model) migrateAttr(item models.Package, python bool) Model {
+	from := item.NixAttr
+	to := nix.PackageAvailability(m.Config.NixpkgsURL, item).RenamedTo
+	listed := func(pkg models.Package) bool { return pkg.NixAttr == to }
+	if python && !slices.ContainsFunc(m.Packages, listed) {
+		m.Packages = append(m.Packages, models.Package{Name: to, NixAttr: to, Description: "Renamed from " + from})
+	}
+	if !python && !slices.ContainsFunc(m.Tools, listed) {
+		m.Tools = append(m.Tools, models.Package{Name: to, NixAttr: to, Description: "Renamed from " + from})
+	}
+
+	if source, ok := m.Config.PackageSources[from]; ok {
+		m.Config.PackageSources[to] = source
+		delete(m.Config.PackageSources, from)
+	}
+	if override, ok := m.PackageOverrides[from]; ok {
+		m.PackageOverrides[to] = override
+		delete(m.PackageOverrides, from)
+	}
+	selections := m.selections(python)
+	delete(selections, from)
+	return m.selectChecked(to, selections)
+}
+
+// updateIssuePrompt handles the prompt for an insecure or broken package:
+// allowing it selects it, declining leaves it unselected
+func (m Model) updateIssuePrompt(msg tea.Msg, selections map[string]bool) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "y", "enter":
+			selections[m.PendingIssue.Attr] = true
+			m.PendingIssue = nil
+		case "n":
+			m.PendingIssue = nil
+		}
+	}
+	return m, nil
+}
+
+// Fields of the package override editor, in display order
+const (
+	overrideFieldVersion = iota
+	overrideFieldPyPIName
+	overrideFieldChecks
+	overrideFieldPatches
+	overrideFieldRelaxDeps
+	overrideFieldCount
+)
+
+// overrideFieldValue returns the text shown in the input for a text field.
+func overrideFieldValue(o models.PackageOverride, field int) string {
+	switch field {
+	case overrideFieldVersion:
+		return o.Version
+	case overrideFieldPyPIName:
+		return o.PyPIName
+	case overrideFieldPatches:
+		return strings.Join(o.Patches, ", ")
+	case overrideFieldRelaxDeps:
+		return strings.Join(o.RelaxDeps, ", ")
+	}
+	return ""
+}
+
+// setOverrideField stores the input text into a text field of the override.
+func setOverrideField(o models.PackageOverride, field int, value string) models.PackageOverride {
+	switch field {
+	case overrideFieldVersion:
+		o.Version = strings.TrimSpace(value)
+	case overrideFieldPyPIName:
+		o.PyPIName = strings.TrimSpace(value)
+	case overrideFieldPatches:
+		o.Patches = splitPaths(value)
+	case overrideFieldRelaxDeps:
+		o.RelaxDeps = splitList(value)
+	}
+	return o
+}
+
+// splitList splits a comma- or space-separated list, dropping empty entries.
+func splitList(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// splitPaths splits a comma-separated list of paths, which may contain spaces.
+func splitPaths(value string) []string {
+	var paths []string
+	for _, path := range strings.Split(value, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// updateOverrideEditor handles the per-package override form (version pin,
+// tests, patches, relaxed deps)
+func (m Model) updateOverrideEditor(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "tab", "down", "shift+tab", "up":
+			delta := 1
+			if msg.String() == "shift+tab" || msg.String() == "up" {
+				delta = overrideFieldCount - 1
+			}
+			m.OverrideDraft = setOverrideField(m.OverrideDraft, m.OverrideField, m.TextInput.Value())
+			m.OverrideField = (m.OverrideField + delta) % overrideFieldCount
+			m.TextInput.SetValue(overrideFieldValue(m.OverrideDraft, m.OverrideField))
+			return m, nil
+		case " ":
+			if m.OverrideField == overrideFieldChecks {
+				m.OverrideDraft.DisableChecks = !m.OverrideDraft.DisableChecks
+				return m, nil
+			}
+		case "enter":
+			m.OverrideDraft = setOverrideField(m.OverrideDraft, m.OverrideField, m.TextInput.Value())
+			if m.OverrideDraft.IsEmpty() {
+				delete(m.PackageOverrides, m.OverrideAttr)
+			} else {
+				m.PackageOverrides[m.OverrideAttr] = m.OverrideDraft
+				m.SelectedPackages[m.OverrideAttr] = true
+			}
+			m.EditingOverride = false
+			m.TextInput.SetValue("")
+			m.TextInput.Blur()
+			return m, nil
+		case "esc":
+			m.EditingOverride = false
+			m.TextInput.SetValue("")
+			m.TextInput.Blur()
+			return m, nil
+		}
+	}
+	if m.OverrideField == overrideFieldChecks {
+		return m, nil
+	}
+	m.TextInput, cmd = m.TextInput.Update(msg)
+	return m, cmd
+}
+
+// updateToolSelector handles multi-select tool selection
+func (m Model) updateToolSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	if m.PendingIssue != nil {
+		return m.updateIssuePrompt(msg, m.SelectedTools)
+	}
+	if m.SearchingIndex {
+		return m.updateIndexSearch(msg, false)
+	}
+
+	// Handle text input when adding custom tool
+	if m.AddingCustomTool {
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "enter":
+				return m.submitCustomAttr(false)
+			case "tab":
+				m = m.useSuggestion()
+				return m, nil
+			case "esc":
+				m.AddingCustomTool = false
+				m.CheckingAttr = false
+				m.AttrCheck = nil
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+		}
+		m.TextInput, cmd = m.TextInput.Update(msg)
+		return m, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if m.Cursor > 0 {
+				m.Cursor--
+			}
+		case "down", "j":
+			if m.Cursor < len(m.Tools)-1 {
+				m.Cursor++
+			}
+		case "left", "h":
+			if step := m.columnStep(m.Tools); step > 0 && m.Cursor >= step {
 				m.Cursor -= step
 			}
 		case "right", "l":
@@ -701,17 +1560,34 @@ func (m Model) updateToolSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		case " ": // Spacebar toggles selection
 			if m.Cursor >= 0 && m.Cursor < len(m.Tools) {
-				tool := m.Tools[m.Cursor]
-				m.SelectedTools[tool.NixAttr] = !m.SelectedTools[tool.NixAttr]
+				m = m.toggleItem(m.Tools[m.Cursor], false)
 			}
-		case "a": // Select all
+		case "a": // Select all (flagged tools need to be allowed one by one)
 			for _, tool := range m.Tools {
-				m.SelectedTools[tool.NixAttr] = true
+				if m.selectable(tool) {
+					m.SelectedTools[tool.NixAttr] = true
+				}
+			}
+		case "M": // Migrate selected tools the channel renamed
+			for _, tool := range m.renamedSelections(m.Tools, m.SelectedTools) {
+				m = m.migrateAttr(tool, false)
 			}
 		case "n": // Select none
 			m.SelectedTools = make(map[string]bool)
+		case "i": // Cycle the input the tool under the cursor comes from
+			if m.Cursor >= 0 && m.Cursor < len(m.Tools) {
+				m = m.cycleSource(m.Tools[m.Cursor].NixAttr, false)
+			}
+		case "/": // Fuzzy-search the package index
+			m.SearchingIndex = true
+			m.SearchResults = nil
+			m.SearchCursor = 0
+			m.TextInput.SetValue("")
+			m.TextInput.Focus()
+			return m, nil
 		case "c": // Add custom tool from nixpkgs
 			m.AddingCustomTool = true
+			m.AttrCheck = nil
 			m.TextInput.SetValue("")
 			m.TextInput.Focus()
 			return m, nil
@@ -729,7 +1605,7 @@ func (m Model) updateToolSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 			if len(m.Features) > 0 {
 				m.CurrentScreen = ScreenFeatureSelector
 			} else {
-				m.CurrentScreen = ScreenConfirmation
+				m.CurrentScreen = ScreenShellHookEditor
 			}
 			return m, nil
 		}
@@ -737,8 +1613,238 @@ func (m Model) updateToolSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-// updateFeatureSelector handles multi-select feature selection
+// featureRow is one line of the feature selector: a feature, or one of its
+// parameters when the feature is selected.
+type featureRow struct {
+	Feature models.Feature
+	Param   *models.FeatureParam // nil for the feature line itself
+}
+
+// featureRows lists the feature selector lines, expanding parameters of selected features.
+func (m Model) featureRows() []featureRow {
+	var rows []featureRow
+	for _, feature := range m.Features {
+		rows = append(rows, featureRow{Feature: feature})
+		if !m.SelectedFeatures[feature.Name] {
+			continue
+		}
+		for i := range feature.Params {
+			rows = append(rows, featureRow{Feature: feature, Param: &feature.Params[i]})
+		}
+	}
+	return rows
+}
+
+// cycleFeatureParam moves a parameter's choice by delta, wrapping around.
+func (m Model) cycleFeatureParam(param models.FeatureParam, delta int) Model {
+	choices := nix.ParamChoices(param, m.SelectedNixpkgs)
+	if len(choices) == 0 {
+		return m
+	}
+	current := 0
+	for i, choice := range choices {
+		if choice == m.FeatureParams[param.Key] {
+			current = i
+			break
+		}
+	}
+	next := (current + delta + len(choices)) % len(choices)
+	params := make(map[string]string, len(m.FeatureParams))
+	for k, v := range m.FeatureParams {
+		params[k] = v
+	}
+	params[param.Key] = choices[next]
+	m.FeatureParams = params
+	return m
+}
+
+// setFeatureParam stores a typed-in parameter value.
+func (m Model) setFeatureParam(param models.FeatureParam, value string) Model {
+	params := make(map[string]string, len(m.FeatureParams))
+	for k, v := range m.FeatureParams {
+		params[k] = v
+	}
+	params[param.Key] = value
+	m.FeatureParams = params
+	return m
+}
+
+// editFeatureParam opens the text input for a free-text parameter, or cycles
+// a choice parameter by delta.
+func (m Model) editFeatureParam(param models.FeatureParam, delta int) Model {
+	if !param.FreeText {
+		return m.cycleFeatureParam(param, delta)
+	}
+	m.EditingFeatureParam = true
+	m.TextInput.SetValue(m.FeatureParams[param.Key])
+	m.TextInput.CursorEnd()
+	m.TextInput.Focus()
+	return m
+}
+
+// applyFeatures copies the selected features and their parameters into the config.
+func (m Model) applyFeatures() Model {
+	m.Config.EnabledFeatures = make([]string, 0)
+	m.Config.UseFHS = m.SelectedFeatures[nix.FeatureFHS]
+	m.Config.UseVenv = m.SelectedFeatures[nix.FeatureVenv]
+	m.Config.UseUv2nix = m.SelectedFeatures[nix.FeatureUv2nix]
+	m.Config.UseCUDA = m.SelectedFeatures[nix.FeatureCUDA]
+	m.Config.UseROCm = m.SelectedFeatures[nix.FeatureROCm]
+	m.Config.UseChecks = m.SelectedFeatures[nix.FeatureChecks]
+	m.Config.UseImage = m.SelectedFeatures[nix.FeatureImage]
+	m.Config.UseGitHooks = m.SelectedFeatures[nix.FeatureGitHooks]
+	m.Config.FeatureParams = make(map[string]string)
+	for _, feature := range m.Features {
+		if !m.SelectedFeatures[feature.Name] || feature.Name == nix.FeatureFHS {
+			continue
+		}
+		m.Config.EnabledFeatures = append(m.Config.EnabledFeatures, feature.NixAttrs...)
+		for _, param := range feature.Params {
+			m.Config.FeatureParams[param.Key] = m.FeatureParams[param.Key]
+		}
+	}
+	return m
+}
+
+// updateFeatureSelector handles multi-select feature selection and feature parameters
 func (m Model) updateFeatureSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
+	rows := m.featureRows()
+
+	// Handle text input when typing a free-text parameter
+	if m.EditingFeatureParam {
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "enter":
+				if m.Cursor >= 0 && m.Cursor < len(rows) && rows[m.Cursor].Param != nil {
+					m = m.setFeatureParam(*rows[m.Cursor].Param, strings.TrimSpace(m.TextInput.Value()))
+				}
+				m.EditingFeatureParam = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			case "esc":
+				m.EditingFeatureParam = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+		}
+		var cmd tea.Cmd
+		m.TextInput, cmd = m.TextInput.Update(msg)
+		return m, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if m.Cursor > 0 {
+				m.Cursor--
+			}
+		case "down", "j":
+			if m.Cursor < len(rows)-1 {
+				m.Cursor++
+			}
+		case "left", "h":
+			if m.Cursor >= 0 && m.Cursor < len(rows) && rows[m.Cursor].Param != nil {
+				m = m.editFeatureParam(*rows[m.Cursor].Param, -1)
+			}
+		case "right", "l":
+			if m.Cursor >= 0 && m.Cursor < len(rows) && rows[m.Cursor].Param != nil {
+				m = m.editFeatureParam(*rows[m.Cursor].Param, 1)
+			}
+		case " ": // Spacebar toggles a feature or cycles a parameter
+			if m.Cursor >= 0 && m.Cursor < len(rows) {
+				row := rows[m.Cursor]
+				if row.Param != nil {
+					m = m.editFeatureParam(*row.Param, 1)
+				} else {
+					key := row.Feature.Name
+					m.SelectedFeatures[key] = !m.SelectedFeatures[key]
+				}
+			}
+		case "enter":
+			m = m.applyFeatures()
+			m.Cursor = 0
+			m.CurrentScreen = ScreenShellHookEditor
+			return m, nil
+		}
+	}
+	return m, nil
+}
+
+// updateShellHookEditor handles snippet selection and custom shellHook commands
+func (m Model) updateShellHookEditor(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	// Handle text input when adding a custom command
+	if m.AddingShellHookLine {
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "enter":
+				value := m.TextInput.Value()
+				if value != "" {
+					m.Snippets = append(m.Snippets, models.ShellSnippet{
+						Name:        value,
+						Description: "Custom command",
+						Lines:       []string{value},
+					})
+					m.SelectedSnippets[value] = true
+					m.TextInput.SetValue("")
+				}
+				return m, nil
+			case "esc":
+				m.AddingShellHookLine = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+		}
+		m.TextInput, cmd = m.TextInput.Update(msg)
+		return m, cmd
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if m.Cursor > 0 {
+				m.Cursor--
+			}
+		case "down", "j":
+			if m.Cursor < len(m.Snippets)-1 {
+				m.Cursor++
+			}
+		case " ": // Spacebar toggles selection
+			if m.Cursor >= 0 && m.Cursor < len(m.Snippets) {
+				snippet := m.Snippets[m.Cursor]
+				m.SelectedSnippets[snippet.Name] = !m.SelectedSnippets[snippet.Name]
+			}
+		case "c": // Add custom shellHook command
+			m.AddingShellHookLine = true
+			m.TextInput.SetValue("")
+			m.TextInput.Focus()
+			return m, nil
+		case "enter":
+			// Collect lines of selected snippets, in list order
+			m.Config.ShellHook = make([]string, 0)
+			for _, snippet := range m.Snippets {
+				if m.SelectedSnippets[snippet.Name] {
+					m.Config.ShellHook = append(m.Config.ShellHook, snippet.Lines...)
+				}
+			}
+			m.Cursor = 0
+			m.CurrentScreen = ScreenServiceSelector
+			return m, nil
+		}
+	}
+	return m, nil
+}
+
+// updateServiceSelector handles multi-select of local services
+func (m Model) updateServiceSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
 		switch msg.String() {
@@ -747,23 +1853,19 @@ func (m Model) updateFeatureSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.Cursor--
 			}
 		case "down", "j":
-			if m.Cursor < len(m.Features)-1 {
+			if m.Cursor < len(nix.Services)-1 {
 				m.Cursor++
 			}
 		case " ": // Spacebar toggles selection
-			if m.Cursor >= 0 && m.Cursor < len(m.Features) {
-				feature := m.Features[m.Cursor]
-				// Toggle all NixAttrs for this feature
-				key := feature.Name
-				m.SelectedFeatures[key] = !m.SelectedFeatures[key]
+			if m.Cursor >= 0 && m.Cursor < len(nix.Services) {
+				name := nix.Services[m.Cursor].Name
+				m.SelectedServices[name] = !m.SelectedServices[name]
 			}
 		case "enter":
-			// Collect selected features' NixAttrs (skip the FHS sentinel)
-			m.Config.EnabledFeatures = make([]string, 0)
-			m.Config.UseFHS = m.SelectedFeatures["FHS Environment"]
-			for _, feature := range m.Features {
-				if m.SelectedFeatures[feature.Name] && feature.Name != "FHS Environment" {
-					m.Config.EnabledFeatures = append(m.Config.EnabledFeatures, feature.NixAttrs...)
+			m.Config.Services = make([]string, 0)
+			for _, service := range nix.Services {
+				if m.SelectedServices[service.Name] {
+					m.Config.Services = append(m.Config.Services, service.Name)
 				}
 			}
 			m.CurrentScreen = ScreenConfirmation
@@ -776,11 +1878,14 @@ func (m Model) updateFeatureSelector(msg tea.Msg) (tea.Model, tea.Cmd) {
 func (m Model) updateConfirmation(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.Loading {
+			return m, nil
+		}
 		if m.AskingOverwrite {
 			switch msg.String() {
 			case "y", "enter":
 				m.AskingOverwrite = false
-				return m.writeAndComplete(), nil
+				return m.startGeneration()
 			case "n", "q", "esc":
 				m.AskingOverwrite = false
 				return m, nil
@@ -790,6 +1895,9 @@ func (m Model) updateConfirmation(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		switch msg.String() {
 		case "enter", "y":
+			if nix.HasErrors(nix.Validate(m.Config)) {
+				return m, nil
+			}
 			// Check if output file already exists
 			if m.Config.Mode != "quick" {
 				if _, err := os.Stat(m.Config.OutputPath); err == nil {
@@ -797,29 +1905,374 @@ func (m Model) updateConfirmation(msg tea.Msg) (tea.Model, tea.Cmd) {
 					return m, nil
 				}
 			}
-			return m.writeAndComplete(), nil
+			return m.startGeneration()
+		case "s":
+			if m.Config.Mode != "quick" {
+				m.Cursor = 0
+				m.CurrentScreen = ScreenShellProfiles
+			}
+			return m, nil
+		case "u":
+			if m.Config.Mode != "quick" {
+				m.Config.AllowUnfree = !m.Config.AllowUnfree
+			}
+			return m, nil
+		case "f":
+			if m.Config.Mode != "quick" {
+				m.Config, _ = nix.AutoFix(m.Config)
+				m = m.syncSelections()
+			}
+			return m, nil
+		}
+	}
+	return m, nil
+}
+
+// syncSelections mirrors an auto-fixed config back into the wizard state, so
+// going back to a screen shows (and keeps) the fixed selection.
+func (m Model) syncSelections() Model {
+	listed := func(items []models.Package, attr string) bool {
+		return slices.ContainsFunc(items, func(pkg models.Package) bool { return pkg.NixAttr == attr })
+	}
+	m.SelectedPackages = make(map[string]bool)
+	for _, attr := range m.Config.Packages {
+		if !listed(m.Packages, attr) {
+			m.Packages = append(m.Packages, models.Package{Name: attr, NixAttr: attr, Description: "Custom package"})
+		}
+		m.SelectedPackages[attr] = true
+	}
+	m.SelectedTools = make(map[string]bool)
+	for _, attr := range m.Config.Tools {
+		if !listed(m.Tools, attr) {
+			m.Tools = append(m.Tools, models.Package{Name: attr, NixAttr: attr, Description: "Custom tool"})
+		}
+		m.SelectedTools[attr] = true
+	}
+	for attr, override := range m.Config.PackageOverrides {
+		m.PackageOverrides[attr] = override
+	}
+
+	var envVars []string
+	for _, v := range m.EnvVars {
+		if !slices.Contains(envVars, v) {
+			envVars = append(envVars, v)
+		}
+	}
+	m.EnvVars = envVars
+
+	m.SelectedFeatures[nix.FeatureFHS] = m.Config.UseFHS
+	m.SelectedFeatures[nix.FeatureVenv] = m.Config.UseVenv
+	m.SelectedFeatures[nix.FeatureUv2nix] = m.Config.UseUv2nix
+	m.SelectedFeatures[nix.FeatureCUDA] = m.Config.UseCUDA
+	m.SelectedFeatures[nix.FeatureROCm] = m.Config.UseROCm
+	m.SelectedFeatures[nix.FeatureChecks] = m.Config.UseChecks
+	m.SelectedFeatures[nix.FeatureImage] = m.Config.UseImage
+	m.SelectedFeatures[nix.FeatureGitHooks] = m.Config.UseGitHooks
+	if m.FeatureParams == nil {
+		m.FeatureParams = make(map[string]string)
+	}
+	for key, value := range m.Config.FeatureParams {
+		m.FeatureParams[key] = value
+	}
+	return m
+}
+
+// updateShellProfiles manages the extra devShells generated next to devShells.default
+func (m Model) updateShellProfiles(msg tea.Msg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+
+	// Handle text input when naming a new profile
+	if m.AddingShellProfile {
+		switch msg := msg.(type) {
+		case tea.KeyMsg:
+			switch msg.String() {
+			case "enter":
+				name := strings.TrimSpace(m.TextInput.Value())
+				if !nix.ValidShellName(name) || m.hasShellProfile(name) {
+					return m, nil
+				}
+				// A profile starts as a copy of the current selection
+				m.Config.ExtraShells = append(m.Config.ExtraShells, m.currentShellProfile(name))
+				m.Cursor = len(m.Config.ExtraShells) - 1
+				m.AddingShellProfile = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			case "esc":
+				m.AddingShellProfile = false
+				m.TextInput.SetValue("")
+				m.TextInput.Blur()
+				return m, nil
+			}
+		}
+		m.TextInput, cmd = m.TextInput.Update(msg)
+		return m, cmd
+	}
+
+	if m.EditingShellProfile {
+		return m.updateShellProfileEditor(msg)
+	}
+
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "up", "k":
+			if m.Cursor > 0 {
+				m.Cursor--
+			}
+		case "down", "j":
+			if m.Cursor < len(m.Config.ExtraShells)-1 {
+				m.Cursor++
+			}
+		case "e": // Edit the packages, tools and features of the profile under the cursor
+			if m.Cursor >= 0 && m.Cursor < len(m.Config.ExtraShells) {
+				m.EditingShellProfile = true
+				m.ProfileRowCursor = 0
+			}
+		case "r": // Reset the profile under the cursor to the current selection
+			if m.Cursor >= 0 && m.Cursor < len(m.Config.ExtraShells) {
+				m.Config.ExtraShells[m.Cursor] = m.currentShellProfile(m.Config.ExtraShells[m.Cursor].Name)
+			}
+		case "c": // Clone the current selection into a new profile
+			m.AddingShellProfile = true
+			m.TextInput.SetValue("")
+			m.TextInput.Focus()
+			return m, nil
+		case "x": // Strip editors from the profile under the cursor
+			if m.Cursor >= 0 && m.Cursor < len(m.Config.ExtraShells) {
+				profile := &m.Config.ExtraShells[m.Cursor]
+				profile.Tools = nix.WithoutEditors(profile.Tools)
+			}
+		case "d": // Delete the profile under the cursor
+			if m.Cursor >= 0 && m.Cursor < len(m.Config.ExtraShells) {
+				shells := make([]models.ShellProfile, 0, len(m.Config.ExtraShells)-1)
+				shells = append(shells, m.Config.ExtraShells[:m.Cursor]...)
+				m.Config.ExtraShells = append(shells, m.Config.ExtraShells[m.Cursor+1:]...)
+				if m.Cursor > 0 && m.Cursor >= len(m.Config.ExtraShells) {
+					m.Cursor--
+				}
+			}
+		case "enter":
+			m.CurrentScreen = ScreenConfirmation
+			return m, nil
+		}
+	}
+	return m, nil
+}
+
+// currentShellProfile returns a profile holding the packages, tools and
+// per-shell features of the current selection.
+func (m Model) currentShellProfile(name string) models.ShellProfile {
+	profile := models.ShellProfile{
+		Name:     name,
+		Packages: slices.Clone(m.Config.Packages),
+		Tools:    slices.Clone(m.Config.Tools),
+	}
+	for _, feature := range nix.ShellFeatures {
+		if m.SelectedFeatures[feature] {
+			profile.Features = append(profile.Features, feature)
+		}
+	}
+	return profile
+}
+
+// profileRow is one line of the devShell profile editor.
+type profileRow struct {
+	Kind string // "feature", "package" or "tool"
+	Attr string // feature name, or package/tool NixAttr
+}
+
+// profileRows lists what a profile can toggle: the per-shell features of the
+// language, then the packages and tools of the wizard's lists plus any the
+// profile holds that are no longer listed.
+func (m Model) profileRows(profile models.ShellProfile) []profileRow {
+	var rows []profileRow
+	for _, feature := range m.Features {
+		if slices.Contains(nix.ShellFeatures, feature.Name) {
+			rows = append(rows, profileRow{Kind: "feature", Attr: feature.Name})
+		}
+	}
+	add := func(kind string, items []models.Package, held []string) {
+		var attrs []string
+		for _, item := range items {
+			attrs = append(attrs, item.NixAttr)
+		}
+		for _, attr := range held {
+			if !slices.Contains(attrs, attr) {
+				attrs = append(attrs, attr)
+			}
+		}
+		for _, attr := range attrs {
+			rows = append(rows, profileRow{Kind: kind, Attr: attr})
+		}
+	}
+	add("package", m.Packages, profile.Packages)
+	add("tool", m.Tools, profile.Tools)
+	return rows
+}
+
+// profileHas reports whether a profile includes the item of a row.
+func profileHas(profile models.ShellProfile, row profileRow) bool {
+	switch row.Kind {
+	case "feature":
+		return slices.Contains(profile.Features, row.Attr)
+	case "package":
+		return slices.Contains(profile.Packages, row.Attr)
+	}
+	return slices.Contains(profile.Tools, row.Attr)
+}
+
+// toggleItem removes item from items when present and appends it otherwise.
+func toggleItem(items []string, item string) []string {
+	if slices.Contains(items, item) {
+		return slices.DeleteFunc(slices.Clone(items), func(i string) bool { return i == item })
+	}
+	return append(slices.Clone(items), item)
+}
+
+// updateShellProfileEditor toggles the features, packages and tools of the
+// profile under the cursor of the devShell screen.
+func (m Model) updateShellProfileEditor(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if m.Cursor < 0 || m.Cursor >= len(m.Config.ExtraShells) {
+		m.EditingShellProfile = false
+		return m, nil
+	}
+	profile := &m.Config.ExtraShells[m.Cursor]
+	rows := m.profileRows(*profile)
+	if msg, ok := msg.(tea.KeyMsg); ok {
+		switch msg.String() {
+		case "up", "k":
+			if m.ProfileRowCursor > 0 {
+				m.ProfileRowCursor--
+			}
+		case "down", "j":
+			if m.ProfileRowCursor < len(rows)-1 {
+				m.ProfileRowCursor++
+			}
+		case " ":
+			if m.ProfileRowCursor >= len(rows) {
+				break
+			}
+			row := rows[m.ProfileRowCursor]
+			switch row.Kind {
+			case "feature":
+				profile.Features = toggleItem(profile.Features, row.Attr)
+			case "package":
+				profile.Packages = toggleItem(profile.Packages, row.Attr)
+			case "tool":
+				profile.Tools = toggleItem(profile.Tools, row.Attr)
+			}
+		case "enter", "esc":
+			m.EditingShellProfile = false
 		}
 	}
 	return m, nil
 }
 
-// writeAndComplete generates/initialises the flake and transitions to the completion screen.
-func (m Model) writeAndComplete() Model {
+// hasShellProfile reports whether an extra devShell with the given name exists
+func (m Model) hasShellProfile(name string) bool {
+	for _, profile := range m.Config.ExtraShells {
+		if profile.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// pypiProgressMsg reports one PyPI lookup of a running generation.
+type pypiProgressMsg struct {
+	events   chan tea.Msg
+	progress nix.PyPIProgress
+}
+
+// flakeGeneratedMsg ends a running generation.
+type flakeGeneratedMsg struct {
+	events  chan tea.Msg
+	content string
+	err     error
+}
+
+// startGeneration initialises quick mode templates right away. Python flakes
+// are generated in the background, since their PyPI lookups take a while;
+// progress arrives as pypiProgressMsg and the result as flakeGeneratedMsg.
+func (m Model) startGeneration() (Model, tea.Cmd) {
 	if m.Config.Mode == "quick" {
 		m.Err = nix.InitializeTemplate(m.Config.SelectedTemplate, ".")
-	} else {
-		content, err := nix.GenerateFlake(m.Config)
-		if err != nil {
-			m.Err = err
-			m.CurrentScreen = ScreenCompletion
-			return m
+		m.CurrentScreen = ScreenCompletion
+		return m, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan tea.Msg)
+	send := func(msg tea.Msg) {
+		select {
+		case events <- msg:
+		case <-ctx.Done():
 		}
-		m.Err = nix.WriteFlake(content, m.Config.OutputPath)
 	}
+	config := m.Config
+	go func() {
+		defer close(events)
+		content, err := nix.GenerateFlakeContext(ctx, config, func(progress nix.PyPIProgress) {
+			send(pypiProgressMsg{events: events, progress: progress})
+		})
+		send(flakeGeneratedMsg{events: events, content: content, err: err})
+	}()
+
+	m.Loading = true
+	m.Cancelled = false
+	m.Resolving = nil
+	m.cancelGenerate = cancel
+	m.generateEvents = events
+	return m, tea.Batch(m.Spinner.Tick, waitForGenerate(events))
+}
+
+// waitForGenerate waits for the next message of a running generation.
+func waitForGenerate(events chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-events // nil once the generation ended
+	}
+}
+
+// applyPyPIProgress adds a pending lookup or updates it with its result.
+func (m Model) applyPyPIProgress(progress nix.PyPIProgress) Model {
+	idx := slices.IndexFunc(m.Resolving, func(p nix.PyPIProgress) bool { return p.Name == progress.Name })
+	if idx < 0 {
+		m.Resolving = append(m.Resolving, progress)
+	} else {
+		m.Resolving = slices.Clone(m.Resolving)
+		m.Resolving[idx] = progress
+	}
+	return m
+}
+
+// cancelGeneration stops a running generation and returns to the confirmation screen.
+func (m Model) cancelGeneration() Model {
+	m = m.endGeneration()
+	m.Cancelled = true
+	return m
+}
+
+// completeGeneration writes the generated flake and transitions to the completion screen.
+func (m Model) completeGeneration(content string, err error) Model {
+	m = m.endGeneration()
+	if err == nil {
+		err = nix.WriteFlake(content, m.Config.OutputPath)
+	}
+	m.Err = err
 	m.CurrentScreen = ScreenCompletion
 	return m
 }
 
+func (m Model) endGeneration() Model {
+	m.cancelGenerate()
+	m.Loading = false
+	m.Resolving = nil
+	m.cancelGenerate = nil
+	m.generateEvents = nil
+	return m
+}
+
 // flakeDir returns the absolute directory containing the output flake.nix.
 func (m Model) flakeDir() string {
 	abs, err := filepath.Abs(m.Config.OutputPath)
@@ -889,6 +2342,21 @@ func (m Model) updateCompletion(msg tea.Msg) (tea.Model, tea.Cmd) {
 				return m, tea.ExecProcess(cmd, func(err error) tea.Msg {
 					return tea.Quit()
 				})
+			case "b":
+				if !m.Config.UseImage {
+					return m, nil
+				}
+				// Untracked flakes are invisible to git-based flake refs
+				dir := m.flakeDir()
+				target := ".#dockerImage"
+				if !m.isFlakeTrackedByGit() {
+					target = "path:" + dir + "#dockerImage"
+				}
+				cmd := exec.Command("nix", "build", target)
+				cmd.Dir = dir
+				return m, tea.ExecProcess(cmd, func(err error) tea.Msg {
+					return nil
+				})
 			case "e":
 				editor := os.Getenv("EDITOR")
 				if editor == "" {
@@ -2,6 +2,7 @@ package tui
 
 import (
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/charmbracelet/lipgloss"
@@ -27,6 +28,10 @@ func (m Model) View() string {
 		return m.viewTemplateOrCustom()
 	case ScreenNixpkgsSelector:
 		return m.viewNixpkgsSelector()
+	case ScreenNixpkgsPin:
+		return m.viewNixpkgsPin()
+	case ScreenFlakeInputs:
+		return m.viewFlakeInputs()
 	case ScreenVersionSelector:
 		return m.viewVersionSelector()
 	case ScreenPackageSelector:
@@ -35,8 +40,14 @@ func (m Model) View() string {
 		return m.viewToolSelector()
 	case ScreenFeatureSelector:
 		return m.viewFeatureSelector()
+	case ScreenShellHookEditor:
+		return m.viewShellHookEditor()
+	case ScreenServiceSelector:
+		return m.viewServiceSelector()
 	case ScreenConfirmation:
 		return m.viewConfirmation()
+	case ScreenShellProfiles:
+		return m.viewShellProfiles()
 	case ScreenCompletion:
 		return m.viewCompletion()
 	}
@@ -117,10 +128,124 @@ func (m Model) viewNixpkgsSelector() string {
 	s.WriteString(TitleStyle.Render("Select nixpkgs Channel"))
 	s.WriteString("\n")
 	s.WriteString(SubtitleStyle.Render("The nixpkgs version determines which packages are available"))
+	s.WriteString("\n")
+	_, discovery := nix.LoadChannels()
+	switch {
+	case m.DiscoveringChannels:
+		s.WriteString(fmt.Sprintf("%s Discovering current releases and their Python versions...", m.Spinner.View()))
+	case discovery != nil && m.ChannelDiscoveryErr != nil:
+		s.WriteString(InfoStyle.Render(fmt.Sprintf("Channels discovered on %s (refresh failed: %v)", discovery.DiscoveredAt.Local().Format("2006-01-02"), m.ChannelDiscoveryErr)))
+	case discovery != nil:
+		s.WriteString(InfoStyle.Render(fmt.Sprintf("Channels discovered on %s", discovery.DiscoveredAt.Local().Format("2006-01-02"))))
+	case m.ChannelDiscoveryErr != nil:
+		s.WriteString(InfoStyle.Render(fmt.Sprintf("Built-in channel list (discovery failed: %v)", m.ChannelDiscoveryErr)))
+	default:
+		s.WriteString(InfoStyle.Render("Built-in channel list — press r to discover the current releases with nix eval"))
+	}
 	s.WriteString("\n\n")
 	s.WriteString(m.NixpkgsChannelList.View())
 	s.WriteString("\n\n")
-	s.WriteString(HelpStyle.Render("Press enter to select, esc to go back, q to quit"))
+	s.WriteString(HelpStyle.Render("Press enter to select, p to pin a revision, i for extra inputs, r to rediscover channels, esc to go back, q to quit"))
+	return s.String()
+}
+
+func (m Model) viewFlakeInputs() string {
+	var s strings.Builder
+	s.WriteString("\n")
+	s.WriteString(TitleStyle.Render("Extra Flake Inputs"))
+	s.WriteString("\n")
+	s.WriteString(SubtitleStyle.Render("Packages and tools can come from these inputs (press i on them to choose)"))
+	s.WriteString("\n\n")
+
+	// Show text input overlay if adding an input
+	if m.AddingFlakeInput {
+		s.WriteString("Input (name url [follows], e.g. 'unstable github:NixOS/nixpkgs/nixos-unstable'): ")
+		s.WriteString(m.TextInput.View())
+		s.WriteString("\n")
+		if strings.TrimSpace(m.TextInput.Value()) != "" {
+			if _, err := nix.ParseFlakeInput(m.TextInput.Value(), m.Config.Inputs); err != nil {
+				s.WriteString(InfoStyle.Render(err.Error()))
+				s.WriteString("\n")
+			}
+		}
+		s.WriteString("\n")
+		s.WriteString(HelpStyle.Render("Enter: add | Esc: cancel"))
+		return s.String()
+	}
+
+	if len(m.Config.Inputs) == 0 {
+		s.WriteString(UncheckedStyle.Render("  (none — everything comes from nixpkgs)"))
+		s.WriteString("\n")
+	}
+	for i, input := range m.Config.Inputs {
+		cursorStr := "  "
+		itemText := input.Name
+		if i == m.Cursor {
+			cursorStr = "> "
+			itemText = SelectedItemStyle.Render(itemText)
+		}
+		follows := ""
+		if input.Follows != "" {
+			follows = fmt.Sprintf(" (nixpkgs follows %s)", input.Follows)
+		}
+		s.WriteString(fmt.Sprintf("%s%s - %s%s\n", cursorStr, itemText, input.URL, follows))
+	}
+
+	s.WriteString("\n")
+	s.WriteString(HelpStyle.Render("a: add | d: delete | up/down: navigate | Enter/Esc: back"))
+	return s.String()
+}
+
+func (m Model) viewNixpkgsPin() string {
+	var s strings.Builder
+	s.WriteString("\n")
+	s.WriteString(TitleStyle.Render("Pin nixpkgs Revision"))
+	s.WriteString("\n")
+	s.WriteString(SubtitleStyle.Render(fmt.Sprintf("Use an exact %s commit instead of the moving branch", nix.ChannelBranch(m.SelectedNixpkgs.FlakeURL))))
+	s.WriteString("\n\n")
+
+	// Show text input overlay if typing a revision
+	if m.AddingPinRev {
+		s.WriteString("Revision (40-character commit hash): ")
+		s.WriteString(m.TextInput.View())
+		s.WriteString("\n")
+		rev := strings.ToLower(strings.TrimSpace(m.TextInput.Value()))
+		if rev != "" && !nix.ValidRev(rev) {
+			s.WriteString(InfoStyle.Render("Enter the full commit hash, e.g. from 'nix flake metadata nixpkgs'"))
+			s.WriteString("\n")
+		}
+		if m.ResolvingPinRev {
+			s.WriteString(fmt.Sprintf("%s Looking up the revision...", m.Spinner.View()))
+			s.WriteString("\n")
+		}
+		s.WriteString("\n")
+		s.WriteString(HelpStyle.Render("Enter: pin | Esc: cancel"))
+		return s.String()
+	}
+
+	if m.LoadingRevisions {
+		s.WriteString(fmt.Sprintf("%s Looking up the channel head, recent channel bumps, the registry and flake.lock...", m.Spinner.View()))
+		s.WriteString("\n")
+	} else if len(m.PinRevisions) == 0 {
+		s.WriteString(InfoStyle.Render("No revisions found (nix unavailable or offline) — press c to enter one."))
+		s.WriteString("\n")
+	}
+	for i, rev := range m.PinRevisions {
+		cursorStr := "  "
+		itemText := rev.Rev[:12]
+		if i == m.Cursor {
+			cursorStr = "> "
+			itemText = SelectedItemStyle.Render(itemText)
+		}
+		date := rev.Date
+		if date == "" {
+			date = "unknown date"
+		}
+		s.WriteString(fmt.Sprintf("%s%s  %s  %s\n", cursorStr, itemText, date, rev.Source))
+	}
+
+	s.WriteString("\n")
+	s.WriteString(HelpStyle.Render("Enter: pin | c: enter revision | u: follow the branch | Esc: back"))
 	return s.String()
 }
 
@@ -170,6 +295,15 @@ func (m Model) viewPackageSelector() string {
 	s.WriteString(TitleStyle.Render("Select Packages"))
 	s.WriteString("\n\n")
 
+	if m.PendingIssue != nil {
+		s.WriteString(m.viewIssuePrompt())
+		return s.String()
+	}
+	if m.SearchingIndex {
+		s.WriteString(m.viewIndexSearch("python3Packages"))
+		return s.String()
+	}
+
 	// Show text input overlay if adding custom package
 	if m.AddingCustomPackage {
 		s.WriteString(SubtitleStyle.Render("Add Custom Nixpkg Package"))
@@ -177,7 +311,7 @@ func (m Model) viewPackageSelector() string {
 		s.WriteString("Package name (e.g., 'scipy', 'pillow'): ")
 		s.WriteString(m.TextInput.View())
 		s.WriteString("\n\n")
-		s.WriteString(HelpStyle.Render("Enter: add | Esc: cancel"))
+		s.WriteString(m.viewAttrCheck())
 		return s.String()
 	}
 
@@ -205,10 +339,65 @@ func (m Model) viewPackageSelector() string {
 			}
 			s.WriteString("\n")
 		}
-		s.WriteString("Add package (e.g., 'beautifulsoup4', 'openai'): ")
+		if m.ResolvingPyPITree {
+			s.WriteString(fmt.Sprintf("%s Resolving dependencies on PyPI...\n\n", m.Spinner.View()))
+		} else if m.PyPITree != nil {
+			s.WriteString(viewPyPITree(m.PyPITree))
+			s.WriteString("\n")
+		}
+		if m.PyPITreeErr != nil {
+			s.WriteString(ErrorStyle.Render(m.PyPITreeErr.Error()))
+			s.WriteString("\n\n")
+		}
+		s.WriteString("Add package (e.g., 'beautifulsoup4', 'httpx==0.27.0', 'pydantic[email]>=2,<3'): ")
 		s.WriteString(m.TextInput.View())
 		s.WriteString("\n\n")
-		s.WriteString(HelpStyle.Render("Enter: add (or done if empty) | Space: toggle | up/down: navigate | Esc: cancel"))
+		if m.PyPIInputErr != nil {
+			s.WriteString(ErrorStyle.Render(m.PyPIInputErr.Error()))
+			s.WriteString("\n")
+		}
+		s.WriteString(HelpStyle.Render("Enter: add (or done if empty) | Space: toggle | ctrl+t: dependency tree | up/down: navigate | Esc: cancel"))
+		return s.String()
+	}
+
+	// Show package override editor
+	if m.EditingOverride {
+		s.WriteString(SubtitleStyle.Render(fmt.Sprintf("Override %s", m.OverrideAttr)))
+		s.WriteString("\n\n")
+		labels := []string{
+			overrideFieldVersion:   "Version pin (PyPI)",
+			overrideFieldPyPIName:  "PyPI name",
+			overrideFieldChecks:    "Disable tests",
+			overrideFieldPatches:   "Patches (paths)",
+			overrideFieldRelaxDeps: "Relax deps",
+		}
+		for field, label := range labels {
+			cursor := "  "
+			if field == m.OverrideField {
+				cursor = "> "
+			}
+			var value string
+			switch {
+			case field == overrideFieldChecks:
+				value = UncheckedStyle.Render("[ ]")
+				if m.OverrideDraft.DisableChecks {
+					value = CheckboxStyle.Render("[x]")
+				}
+			case field == m.OverrideField:
+				value = m.TextInput.View()
+			default:
+				value = overrideFieldValue(m.OverrideDraft, field)
+			}
+			line := fmt.Sprintf("%-20s", label)
+			if field == m.OverrideField {
+				line = SelectedItemStyle.Render(line)
+			}
+			s.WriteString(fmt.Sprintf("%s%s %s\n", cursor, line, value))
+		}
+		s.WriteString("\n")
+		s.WriteString(SubtitleStyle.Render("Version pins fetch the sdist hash from PyPI; set the PyPI name when it differs from the package's pname. Lists are comma-separated; patch paths are relative to flake.nix."))
+		s.WriteString("\n")
+		s.WriteString(HelpStyle.Render("Tab/up/down: field | Space: toggle tests | Enter: save | Esc: cancel"))
 		return s.String()
 	}
 
@@ -255,6 +444,7 @@ func (m Model) viewPackageSelector() string {
 	}
 	s.WriteString("\n")
 	s.WriteString(fmt.Sprintf("Selected: %d/%d packages\n", count, len(m.Packages)))
+	s.WriteString(m.viewRenamed(m.Packages, m.SelectedPackages))
 	s.WriteString("\n")
 
 	// Show PyPI packages summary
@@ -269,6 +459,18 @@ func (m Model) viewPackageSelector() string {
 		s.WriteString(" (press p to manage)\n")
 	}
 
+	// Show overrides summary
+	if len(m.PackageOverrides) > 0 {
+		attrs := make([]string, 0, len(m.PackageOverrides))
+		for _, pkg := range m.Packages {
+			if _, ok := m.PackageOverrides[pkg.NixAttr]; ok {
+				attrs = append(attrs, pkg.NixAttr)
+			}
+		}
+		s.WriteString(SelectedItemStyle.Render(fmt.Sprintf("Overrides: %s", strings.Join(attrs, ", "))))
+		s.WriteString(" (press o on a package to edit)\n")
+	}
+
 	// Show env vars summary
 	if len(m.EnvVars) > 0 {
 		sel := 0
@@ -282,7 +484,7 @@ func (m Model) viewPackageSelector() string {
 	}
 
 	s.WriteString("\n")
-	s.WriteString(HelpStyle.Render("Space: toggle | up/down/left/right: navigate | a: all | n: none | c: custom nixpkg | p: PyPI | e: env vars | Enter: continue | Esc: back"))
+	s.WriteString(HelpStyle.Render("Space: toggle | up/down/left/right: navigate | a: all | n: none | /: search | c: custom nixpkg | o: override | p: PyPI | e: env vars | i: input | Enter: continue | Esc: back"))
 	return s.String()
 }
 
@@ -292,6 +494,15 @@ func (m Model) viewToolSelector() string {
 	s.WriteString(TitleStyle.Render("Select Development Tools"))
 	s.WriteString("\n\n")
 
+	if m.PendingIssue != nil {
+		s.WriteString(m.viewIssuePrompt())
+		return s.String()
+	}
+	if m.SearchingIndex {
+		s.WriteString(m.viewIndexSearch("pkgs"))
+		return s.String()
+	}
+
 	// Show text input overlay if adding custom tool
 	if m.AddingCustomTool {
 		s.WriteString(SubtitleStyle.Render("Add Custom Nixpkg Tool"))
@@ -299,7 +510,7 @@ func (m Model) viewToolSelector() string {
 		s.WriteString("Tool name (e.g., 'ripgrep', 'jq', 'htop'): ")
 		s.WriteString(m.TextInput.View())
 		s.WriteString("\n\n")
-		s.WriteString(HelpStyle.Render("Enter: add | Esc: cancel"))
+		s.WriteString(m.viewAttrCheck())
 		return s.String()
 	}
 
@@ -315,8 +526,9 @@ func (m Model) viewToolSelector() string {
 	}
 	s.WriteString("\n")
 	s.WriteString(fmt.Sprintf("Selected: %d/%d tools\n", count, len(m.Tools)))
+	s.WriteString(m.viewRenamed(m.Tools, m.SelectedTools))
 	s.WriteString("\n")
-	s.WriteString(HelpStyle.Render("Space: toggle | up/down/left/right: navigate | a: all | n: none | c: add custom tool | Enter: continue | Esc: back"))
+	s.WriteString(HelpStyle.Render("Space: toggle | up/down/left/right: navigate | a: all | n: none | /: search | c: add custom tool | i: input | Enter: continue | Esc: back"))
 	return s.String()
 }
 
@@ -326,13 +538,33 @@ func (m Model) viewFeatureSelector() string {
 	s.WriteString(TitleStyle.Render("Select Features (Optional)"))
 	s.WriteString("\n\n")
 
-	// Render features as multi-select
-	for i, feature := range m.Features {
+	// Render features as multi-select, with parameters of selected features below them
+	for i, row := range m.featureRows() {
 		cursorStr := "  "
 		if i == m.Cursor {
 			cursorStr = "> "
 		}
 
+		if row.Param != nil {
+			if row.Param.FreeText {
+				value := m.FeatureParams[row.Param.Key]
+				if i == m.Cursor && m.EditingFeatureParam {
+					value = m.TextInput.View()
+				} else if i == m.Cursor {
+					value = SelectedItemStyle.Render(value)
+				}
+				s.WriteString(fmt.Sprintf("%s      %s: %s\n", cursorStr, row.Param.Name, value))
+				continue
+			}
+			value := paramDisplay(row.Param.Key, m.FeatureParams[row.Param.Key])
+			if i == m.Cursor {
+				value = SelectedItemStyle.Render(value)
+			}
+			s.WriteString(fmt.Sprintf("%s      %s: < %s >\n", cursorStr, row.Param.Name, value))
+			continue
+		}
+
+		feature := row.Feature
 		checkbox := UncheckedStyle.Render("[ ]")
 		if m.SelectedFeatures[feature.Name] {
 			checkbox = CheckboxStyle.Render("[x]")
@@ -347,7 +579,112 @@ func (m Model) viewFeatureSelector() string {
 	}
 
 	s.WriteString("\n")
-	s.WriteString(HelpStyle.Render("Space: toggle, up/down: navigate, Enter: continue, Esc: back"))
+	if m.EditingFeatureParam {
+		s.WriteString(HelpStyle.Render("Enter: save | Esc: cancel"))
+		return s.String()
+	}
+	s.WriteString(HelpStyle.Render("Space: toggle/edit, up/down: navigate, left/right: change option, Enter: continue, Esc: back"))
+	return s.String()
+}
+
+// paramDisplay formats a feature parameter choice for display.
+func paramDisplay(key, value string) string {
+	if key == nix.ParamCUDAPackages {
+		return fmt.Sprintf("%s (%s)", nix.CUDAVersionLabel(value), value)
+	}
+	return value
+}
+
+func (m Model) viewShellHookEditor() string {
+	var s strings.Builder
+	s.WriteString("\n")
+	s.WriteString(TitleStyle.Render("Shell Hook"))
+	s.WriteString("\n")
+	s.WriteString(SubtitleStyle.Render("Commands run each time you enter the shell"))
+	s.WriteString("\n\n")
+
+	// Show text input overlay if adding a custom command
+	if m.AddingShellHookLine {
+		s.WriteString(SubtitleStyle.Render("Add Custom Command"))
+		s.WriteString("\n\n")
+		s.WriteString("Command (e.g., 'export PYTHONPATH=$PWD/src'): ")
+		s.WriteString(m.TextInput.View())
+		s.WriteString("\n\n")
+		s.WriteString(HelpStyle.Render("Enter: add | Esc: done"))
+		return s.String()
+	}
+
+	for i, snippet := range m.Snippets {
+		cursorStr := "  "
+		if i == m.Cursor {
+			cursorStr = "> "
+		}
+
+		checkbox := UncheckedStyle.Render("[ ]")
+		if m.SelectedSnippets[snippet.Name] {
+			checkbox = CheckboxStyle.Render("[x]")
+		}
+
+		itemText := snippet.Name
+		if i == m.Cursor {
+			itemText = SelectedItemStyle.Render(itemText)
+		}
+
+		s.WriteString(fmt.Sprintf("%s%s %s - %s\n", cursorStr, checkbox, itemText, snippet.Description))
+	}
+
+	// Preview the commands of the snippet under the cursor
+	if m.Cursor >= 0 && m.Cursor < len(m.Snippets) {
+		s.WriteString("\n")
+		for _, line := range m.Snippets[m.Cursor].Lines {
+			s.WriteString(UncheckedStyle.Render("    " + line))
+			s.WriteString("\n")
+		}
+	}
+
+	s.WriteString("\n")
+	s.WriteString(HelpStyle.Render("Space: toggle | up/down: navigate | c: add custom command | Enter: continue | Esc: back"))
+	return s.String()
+}
+
+func (m Model) viewServiceSelector() string {
+	var s strings.Builder
+	s.WriteString("\n")
+	s.WriteString(TitleStyle.Render("Select Services (Optional)"))
+	s.WriteString("\n")
+	s.WriteString(SubtitleStyle.Render("Started by process-compose when you enter the shell; data in .devenv/state"))
+	s.WriteString("\n\n")
+
+	for i, service := range nix.Services {
+		cursorStr := "  "
+		if i == m.Cursor {
+			cursorStr = "> "
+		}
+
+		checkbox := UncheckedStyle.Render("[ ]")
+		if m.SelectedServices[service.Name] {
+			checkbox = CheckboxStyle.Render("[x]")
+		}
+
+		itemText := service.Name
+		if i == m.Cursor {
+			itemText = SelectedItemStyle.Render(itemText)
+		}
+
+		s.WriteString(fmt.Sprintf("%s%s %s - %s\n", cursorStr, checkbox, itemText, service.Description))
+	}
+
+	// Preview the env vars exported for the service under the cursor
+	if m.Cursor >= 0 && m.Cursor < len(nix.Services) {
+		s.WriteString("\n")
+		for _, env := range nix.Services[m.Cursor].Env {
+			s.WriteString(UncheckedStyle.Render("    export " + env))
+			s.WriteString("\n")
+		}
+	}
+
+	s.WriteString("\n")
+	s.WriteString(HelpStyle.Render("Space: toggle | up/down: navigate | Enter: continue | Esc: back"))
 	return s.String()
 }
 
@@ -390,6 +727,120 @@ func estimateListRows(items []models.Package) int {
 	return rows
 }
 
+// issueKind names how a channel flags a package.
+func issueKind(issue models.PackageIssue) string {
+	if issue.Broken {
+		return "broken"
+	}
+	return "insecure"
+}
+
+// viewIssuePrompt asks whether to allow an insecure or broken package.
+func (m Model) viewIssuePrompt() string {
+	var s strings.Builder
+	issue := *m.PendingIssue
+	s.WriteString(SubtitleStyle.Render(fmt.Sprintf("%s is marked %s on %s", issue.Attr, issueKind(issue), nix.ChannelBranch(m.Config.NixpkgsURL))))
+	s.WriteString("\n\n")
+	s.WriteString(issue.Reason)
+	s.WriteString("\n\n")
+	if issue.Broken {
+		s.WriteString(InfoStyle.Render("Selecting it adds the package to allowBrokenPredicate; the build may still fail."))
+	} else {
+		s.WriteString(InfoStyle.Render(fmt.Sprintf("Selecting it adds %s-%s to permittedInsecurePackages.", issue.Attr, issue.Version)))
+	}
+	s.WriteString("\n\n")
+	s.WriteString(HelpStyle.Render("y/enter: select and allow | n/esc: leave unselected"))
+	return s.String()
+}
+
+// viewAttrCheck shows the validation state of the custom attr prompt and its help line.
+func (m Model) viewAttrCheck() string {
+	var s strings.Builder
+	branch := nix.LoadIndex(m.Config.NixpkgsURL).Branch
+	help := "Enter: add | Esc: cancel"
+	switch check := m.AttrCheck; {
+	case m.CheckingAttr:
+		s.WriteString(fmt.Sprintf("%s Checking %s on %s with nix eval...\n\n", m.Spinner.View(), m.TextInput.Value(), branch))
+		help = "Esc: cancel"
+	case check == nil:
+	case check.Unchecked:
+		s.WriteString(InfoStyle.Render(fmt.Sprintf("Added %s without validation (%s)", check.Attr, check.Reason)))
+		s.WriteString("\n\n")
+	default:
+		s.WriteString(ErrorStyle.Render(fmt.Sprintf("%s is not an attribute of %s", check.Attr, branch)))
+		s.WriteString("\n")
+		if len(check.Suggestions) > 0 {
+			s.WriteString(fmt.Sprintf("Did you mean: %s?\n", SelectedItemStyle.Render(strings.Join(check.Suggestions, ", "))))
+			help = "Tab: use " + check.Suggestions[0] + " | Enter: add anyway | Esc: cancel"
+		} else {
+			help = "Enter: add anyway | Esc: cancel"
+		}
+		s.WriteString("\n")
+	}
+	s.WriteString(HelpStyle.Render(help))
+	return s.String()
+}
+
+// viewIndexSearch shows the fuzzy search over the package index of the channel.
+func (m Model) viewIndexSearch(set string) string {
+	var s strings.Builder
+	index := nix.LoadIndex(m.Config.NixpkgsURL)
+	s.WriteString(SubtitleStyle.Render(fmt.Sprintf("Search %s on %s", set, index.Branch)))
+	s.WriteString("\n")
+	catalogOnly := index.Source == nix.CatalogIndexSource
+	if catalogOnly {
+		s.WriteString(InfoStyle.Render(fmt.Sprintf("Index: %s only, %d packages — the search covers the curated catalog, not all of nixpkgs", index.Source, len(index.Entries))))
+	} else {
+		s.WriteString(InfoStyle.Render(fmt.Sprintf("Index: %s, %d packages", index.Source, len(index.Entries))))
+	}
+	s.WriteString("\n\n")
+	s.WriteString("Name: ")
+	s.WriteString(m.TextInput.View())
+	s.WriteString("\n\n")
+
+	if m.TextInput.Value() != "" && len(m.SearchResults) == 0 {
+		if catalogOnly {
+			s.WriteString(DisabledStyle.Render("  No matches in the curated catalog (Esc, then c adds any other attr)"))
+		} else {
+			s.WriteString(DisabledStyle.Render("  No matches"))
+		}
+		s.WriteString("\n")
+	}
+	for i, entry := range m.SearchResults {
+		cursor := "  "
+		name := entry.Attr
+		if i == m.SearchCursor {
+			cursor = "> "
+			name = SelectedItemStyle.Render(name)
+		}
+		if entry.Version != "" {
+			name += " " + entry.Version
+		}
+		var flags []string
+		if entry.Unfree {
+			flags = append(flags, "unfree")
+		}
+		if entry.Broken {
+			flags = append(flags, "broken")
+		}
+		if entry.License != "" {
+			flags = append(flags, entry.License)
+		}
+		if len(flags) > 0 {
+			name += InfoStyle.Render(" [" + strings.Join(flags, ", ") + "]")
+		}
+		s.WriteString(fmt.Sprintf("%s%s - %s\n", cursor, name, entry.Description))
+	}
+	if catalogOnly {
+		s.WriteString("\n")
+		s.WriteString(InfoStyle.Render(fmt.Sprintf("Only curated packages are indexed; run with -update-index %s (needs nix) to search all of nixpkgs.", index.Branch)))
+		s.WriteString("\n")
+	}
+	s.WriteString("\n")
+	s.WriteString(HelpStyle.Render("Type to search | up/down: navigate | Enter: add and select | Esc: cancel"))
+	return s.String()
+}
+
 // renderColItems renders a subset of items as a single column, using globalStart to
 // correctly identify the cursor position within the full items slice.
 func (m Model) renderColItems(items []models.Package, selections map[string]bool, globalStart int) string {
@@ -412,6 +863,17 @@ func (m Model) renderColItems(items []models.Package, selections map[string]bool
 			cursorStr = "> "
 		}
 
+		// Items the channel lacks are dimmed like unsupported Python versions
+		if availability := nix.PackageAvailability(m.Config.NixpkgsURL, item); !availability.Available {
+			checkbox := "[ ]"
+			if selections[item.NixAttr] {
+				checkbox = "[x]"
+			}
+			s.WriteString(DisabledStyle.Render(fmt.Sprintf("%s%s %s - %s  [%s]", cursorStr, checkbox, item.Name, item.Description, availability.Reason)))
+			s.WriteString("\n")
+			continue
+		}
+
 		checkbox := UncheckedStyle.Render("[ ]")
 		if selections[item.NixAttr] {
 			checkbox = CheckboxStyle.Render("[x]")
@@ -422,11 +884,51 @@ func (m Model) renderColItems(items []models.Package, selections map[string]bool
 			itemText = SelectedItemStyle.Render(itemText)
 		}
 
+		if source := m.Config.PackageSources[item.NixAttr]; source != "" {
+			itemText += InfoStyle.Render(" @" + source)
+		}
+		if issue, ok := nix.ChannelIssue(m.Config.NixpkgsURL, item.NixAttr); ok {
+			itemText += InfoStyle.Render(" (" + issueKind(issue) + ")")
+		}
+
 		s.WriteString(fmt.Sprintf("%s%s %s - %s\n", cursorStr, checkbox, itemText, item.Description))
 	}
 	return s.String()
 }
 
+// viewDiagnostics lists the problems nix.Validate found, errors first.
+func viewDiagnostics(diagnostics []nix.Diagnostic) string {
+	if len(diagnostics) == 0 {
+		return ""
+	}
+	var s strings.Builder
+	s.WriteString("\n")
+	for _, d := range diagnostics {
+		line := d.Message
+		if d.Fix != nil {
+			line += " (f: " + d.FixHint + ")"
+		}
+		if d.Severity == nix.SeverityError {
+			s.WriteString(ErrorStyle.Render("✗ " + line))
+		} else {
+			s.WriteString(InfoStyle.Render("! " + line))
+		}
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
+// viewRenamed lists the selected items the channel has under a new attr.
+func (m Model) viewRenamed(items []models.Package, selections map[string]bool) string {
+	var s strings.Builder
+	for _, item := range m.renamedSelections(items, selections) {
+		availability := nix.PackageAvailability(m.Config.NixpkgsURL, item)
+		s.WriteString(InfoStyle.Render(fmt.Sprintf("%s was %s — M: migrate", item.NixAttr, availability.Reason)))
+		s.WriteString("\n")
+	}
+	return s.String()
+}
+
 // renderMultiSelectList renders a multi-select list with checkboxes and category headers.
 // When the list is too tall for the terminal, it splits into multiple columns.
 func (m Model) renderMultiSelectList(items []models.Package, selections map[string]bool) string {
@@ -500,6 +1002,30 @@ func (m Model) viewConfirmation() string {
 		}
 
 		s.WriteString(fmt.Sprintf("Version: %s\n", SelectedItemStyle.Render(m.Config.LanguageVersion)))
+		for _, input := range m.Config.Inputs {
+			var attrs []string
+			for _, attr := range append(append([]string{}, m.Config.Packages...), m.Config.Tools...) {
+				if m.Config.PackageSources[attr] == input.Name {
+					attrs = append(attrs, attr)
+				}
+			}
+			s.WriteString(fmt.Sprintf("Input %s: %s", input.Name, input.URL))
+			if len(attrs) > 0 {
+				s.WriteString(" → " + strings.Join(attrs, ", "))
+			}
+			s.WriteString("\n")
+		}
+		if m.Config.NixpkgsRev != "" {
+			date := m.Config.NixpkgsRevDate
+			if date == "" {
+				date = "unknown date"
+			}
+			// The revision may come from another branch (registry, flake.lock)
+			if branch := m.Config.NixpkgsRevBranch; branch != "" && branch != nix.ChannelBranch(m.Config.NixpkgsURL) {
+				date = branch + ", " + date
+			}
+			s.WriteString(fmt.Sprintf("nixpkgs: %s pinned to %s (%s)\n", nix.ChannelBranch(m.Config.NixpkgsURL), SelectedItemStyle.Render(m.Config.NixpkgsRev[:12]), date))
+		}
 
 		// Show packages
 		if len(m.Config.Packages) > 0 {
@@ -510,6 +1036,28 @@ func (m Model) viewConfirmation() string {
 			s.WriteString("Packages: (none)\n")
 		}
 
+		// Show package overrides
+		for _, attr := range m.Config.Packages {
+			override, ok := m.Config.PackageOverrides[attr]
+			if !ok {
+				continue
+			}
+			var parts []string
+			if override.Version != "" {
+				parts = append(parts, "version "+override.Version)
+			}
+			if override.DisableChecks {
+				parts = append(parts, "no tests")
+			}
+			if len(override.Patches) > 0 {
+				parts = append(parts, fmt.Sprintf("%d patch(es)", len(override.Patches)))
+			}
+			if len(override.RelaxDeps) > 0 {
+				parts = append(parts, "relax "+strings.Join(override.RelaxDeps, ", "))
+			}
+			s.WriteString(fmt.Sprintf("  override %s: %s\n", attr, strings.Join(parts, "; ")))
+		}
+
 		// Show PyPI packages
 		if len(m.Config.PyPIPackages) > 0 {
 			s.WriteString(fmt.Sprintf("PyPI packages (%d): ", len(m.Config.PyPIPackages)))
@@ -537,21 +1085,280 @@ func (m Model) viewConfirmation() string {
 		if len(m.Config.EnabledFeatures) > 0 {
 			s.WriteString(fmt.Sprintf("Features: %s\n", strings.Join(m.Config.EnabledFeatures, ", ")))
 		}
+		if m.Config.UseCUDA {
+			s.WriteString(fmt.Sprintf("CUDA: %s, capability %s, %s builds\n",
+				paramDisplay(nix.ParamCUDAPackages, m.Config.FeatureParams[nix.ParamCUDAPackages]),
+				m.Config.FeatureParams[nix.ParamCUDACapabilities],
+				m.Config.FeatureParams[nix.ParamCUDABuild]))
+		}
+		if m.Config.UseROCm {
+			s.WriteString("ROCm: rocmSupport enabled (AMD GPUs)\n")
+		}
+		if swaps := nix.AcceleratorSwaps(m.Config); len(swaps) > 0 {
+			s.WriteString("Package swaps:\n")
+			for _, swap := range swaps {
+				s.WriteString(fmt.Sprintf("  %s → %s: %s\n", swap.From, SelectedItemStyle.Render(swap.To), swap.Reason))
+			}
+		}
+		if m.Config.UseChecks {
+			var checks []string
+			for _, check := range nix.FlakeChecks {
+				if slices.Contains(m.Config.Packages, check.Attr) || slices.Contains(m.Config.Tools, check.Attr) {
+					checks = append(checks, check.Attr)
+				}
+			}
+			if len(checks) == 0 {
+				checks = []string{"(none — select pytest, ruff, mypy or black)"}
+			}
+			s.WriteString(fmt.Sprintf("Checks: %s; formatter: %s\n", strings.Join(checks, ", "), m.Config.FeatureParams[nix.ParamNixFormatter]))
+		}
+		if m.Config.UseGitHooks {
+			s.WriteString(fmt.Sprintf("Git hooks: %s (installed on 'nix develop', run in 'nix flake check')\n", strings.Join(nix.GitHooks(m.Config), ", ")))
+		}
+		if m.Config.UseImage {
+			s.WriteString(fmt.Sprintf("Image: %s via %s, entrypoint '%s', ports %s\n",
+				nix.ImageName(m.Config.OutputPath),
+				m.Config.FeatureParams[nix.ParamImageBuilder],
+				m.Config.FeatureParams[nix.ParamImageEntrypoint],
+				m.Config.FeatureParams[nix.ParamImagePorts]))
+		}
 		if m.Config.UseFHS {
 			s.WriteString("Shell type: FHS Environment (buildFHSEnv)\n")
 		}
+		if m.Config.UseVenv {
+			s.WriteString("Python env: Nix + .venv (uv sync when uv.lock exists, LD_LIBRARY_PATH for wheels)\n")
+		}
+		if m.Config.UseUv2nix {
+			s.WriteString("Python env: uv2nix virtualenv from ./pyproject.toml + ./uv.lock\n")
+			s.WriteString(InfoStyle.Render("Note: with uv2nix, Python dependencies come from uv.lock; the package and PyPI selections are not used."))
+			s.WriteString("\n")
+		}
+		if len(m.Config.ShellHook) > 0 {
+			s.WriteString(fmt.Sprintf("shellHook: %d line(s)\n", len(m.Config.ShellHook)))
+		}
+		if len(m.Config.Services) > 0 {
+			s.WriteString(fmt.Sprintf("Services: %s (process-compose, state in .devenv/state)\n", strings.Join(m.Config.Services, ", ")))
+		}
+		for _, issue := range nix.SelectedIssues(m.Config) {
+			s.WriteString(fmt.Sprintf("Allowed %s: %s (%s)\n", issueKind(issue), SelectedItemStyle.Render(issue.Attr), issue.Reason))
+		}
+		if m.Config.AllowUnfree {
+			s.WriteString("Unfree: all packages allowed (allowUnfree = true)\n")
+		} else if names, licenses := nix.UnfreeAllowances(m.Config); len(names) > 0 {
+			s.WriteString(fmt.Sprintf("Unfree licenses accepted: %s (allowUnfreePredicate, %d package(s))\n",
+				SelectedItemStyle.Render(strings.Join(licenses, ", ")), len(names)))
+		}
+		if len(m.Config.ExtraShells) > 0 {
+			names := []string{"default"}
+			for _, profile := range m.Config.ExtraShells {
+				names = append(names, profile.Name)
+			}
+			s.WriteString(fmt.Sprintf("Dev shells: %s\n", strings.Join(names, ", ")))
+		}
 
 		s.WriteString(fmt.Sprintf("\nOutput: %s\n", SelectedItemStyle.Render(m.Config.OutputPath)))
 	}
 
+	diagnostics := nix.Validate(m.Config)
+	s.WriteString(viewDiagnostics(diagnostics))
+
 	s.WriteString("\n")
-	if m.AskingOverwrite {
+	if m.Loading {
+		s.WriteString(m.viewResolving())
+		s.WriteString(HelpStyle.Render("ctrl+c: cancel"))
+	} else if m.AskingOverwrite {
 		s.WriteString(InfoStyle.Render(fmt.Sprintf("%s already exists.", m.Config.OutputPath)))
 		s.WriteString("\n")
 		s.WriteString(HelpStyle.Render("y/enter: overwrite | n/esc: cancel"))
 	} else {
-		s.WriteString(HelpStyle.Render("Press enter to confirm, esc to go back, q to quit"))
+		if m.Cancelled {
+			s.WriteString(InfoStyle.Render("Generation cancelled, nothing was written."))
+			s.WriteString("\n")
+		}
+		help := "Press enter to confirm, esc to go back, q to quit"
+		if m.Config.Mode != "quick" {
+			help = "Press enter to confirm, s for extra dev shells, u to toggle global allowUnfree, esc to go back, q to quit"
+		}
+		if nix.HasErrors(diagnostics) {
+			help = "Fix the errors above to continue: f to apply the fixes, esc to go back, q to quit"
+		} else if slices.ContainsFunc(diagnostics, func(d nix.Diagnostic) bool { return d.Fix != nil }) {
+			help = strings.Replace(help, "Press enter to confirm, ", "Press enter to confirm, f to apply the fixes, ", 1)
+		}
+		s.WriteString(HelpStyle.Render(help))
+	}
+	return s.String()
+}
+
+// viewPyPITree shows the selected PyPI packages with their dependencies:
+// those nixpkgs has are leaves, missing ones are built from PyPI as well.
+func viewPyPITree(packages []nix.PyPIPackageInfo) string {
+	byAttr := make(map[string]nix.PyPIPackageInfo, len(packages))
+	for _, pkg := range packages {
+		byAttr[pkg.Attr()] = pkg
+	}
+	label := func(pkg nix.PyPIPackageInfo) string {
+		if !pkg.Resolved {
+			return fmt.Sprintf("%s %s", pkg.Name, ErrorStyle.Render("not found (fakeHash)"))
+		}
+		return fmt.Sprintf("%s %s", pkg.Name, pkg.Version)
+	}
+
+	var s strings.Builder
+	var children func(pkg nix.PyPIPackageInfo, prefix string)
+	children = func(pkg nix.PyPIPackageInfo, prefix string) {
+		deps := append(slices.Clone(pkg.RuntimeDeps), pkg.Cycles...)
+		for i, attr := range deps {
+			branch, next := "├─ ", "│  "
+			if i == len(deps)-1 {
+				branch, next = "└─ ", "   "
+			}
+			when := ""
+			if condition, ok := pkg.DepConditions[attr]; ok {
+				when = DisabledStyle.Render(" if " + condition)
+			}
+			dep, fromPyPI := byAttr[attr]
+			switch {
+			case i >= len(pkg.RuntimeDeps):
+				s.WriteString(prefix + branch + attr + DisabledStyle.Render(" (cycle, left out)") + "\n")
+			case fromPyPI:
+				s.WriteString(prefix + branch + label(dep) + InfoStyle.Render(" PyPI") + when + "\n")
+				children(dep, prefix+next)
+			default:
+				s.WriteString(prefix + branch + attr + DisabledStyle.Render(" nixpkgs") + when + "\n")
+			}
+		}
+	}
+	for _, pkg := range packages {
+		if pkg.RequiredBy == "" {
+			s.WriteString(label(pkg) + "\n")
+			children(pkg, "")
+		}
+	}
+	return s.String()
+}
+
+// viewResolving lists the PyPI lookups of the flake being generated.
+func (m Model) viewResolving() string {
+	if len(m.Resolving) == 0 {
+		return fmt.Sprintf("%s Generating flake...\n", m.Spinner.View())
+	}
+	var s strings.Builder
+	s.WriteString(SubtitleStyle.Render("Resolving PyPI packages"))
+	s.WriteString("\n")
+	for _, p := range m.Resolving {
+		switch {
+		case !p.Done:
+			s.WriteString(fmt.Sprintf("  %s%s\n", m.Spinner.View(), p.Name)) // the spinner frame ends in a space
+		case p.Resolved:
+			s.WriteString(fmt.Sprintf("  %s %s %s\n", CheckboxStyle.Render("✓"), p.Name, DisabledStyle.Render(p.Version)))
+		default:
+			s.WriteString(fmt.Sprintf("  %s %s %s\n", ErrorStyle.Render("✗"), p.Name, DisabledStyle.Render("not found (fakeHash)")))
+		}
+	}
+	return s.String()
+}
+
+func (m Model) viewShellProfiles() string {
+	var s strings.Builder
+	s.WriteString("\n")
+	s.WriteString(TitleStyle.Render("Dev Shells"))
+	s.WriteString("\n")
+	s.WriteString(SubtitleStyle.Render("Extra devShells next to devShells.default (nix develop .#<name>)"))
+	s.WriteString("\n\n")
+
+	// Show text input overlay if naming a new profile
+	if m.AddingShellProfile {
+		s.WriteString(SubtitleStyle.Render("New Shell (copy of the current selection)"))
+		s.WriteString("\n\n")
+		s.WriteString("Name (e.g., 'ci', 'docs'): ")
+		s.WriteString(m.TextInput.View())
+		s.WriteString("\n")
+		name := strings.TrimSpace(m.TextInput.Value())
+		if name != "" && (!nix.ValidShellName(name) || m.hasShellProfile(name)) {
+			s.WriteString(InfoStyle.Render("Use letters, digits, - and _; the name must be new and not 'default'"))
+			s.WriteString("\n")
+		}
+		s.WriteString("\n")
+		s.WriteString(HelpStyle.Render("Enter: add | Esc: cancel"))
+		return s.String()
+	}
+
+	if m.EditingShellProfile && m.Cursor >= 0 && m.Cursor < len(m.Config.ExtraShells) {
+		s.WriteString(m.viewShellProfileEditor(m.Config.ExtraShells[m.Cursor]))
+		return s.String()
 	}
+
+	defaultFeatures := m.currentShellProfile("default").Features
+	s.WriteString(fmt.Sprintf("  default - %s\n", profileSummary(len(m.Config.Packages), len(m.Config.Tools), defaultFeatures)))
+	for i, profile := range m.Config.ExtraShells {
+		cursorStr := "  "
+		itemText := profile.Name
+		if i == m.Cursor {
+			cursorStr = "> "
+			itemText = SelectedItemStyle.Render(itemText)
+		}
+		s.WriteString(fmt.Sprintf("%s%s - %s\n", cursorStr, itemText, profileSummary(len(profile.Packages), len(profile.Tools), profile.Features)))
+	}
+
+	// Show the tools of the profile under the cursor
+	if m.Cursor >= 0 && m.Cursor < len(m.Config.ExtraShells) {
+		tools := m.Config.ExtraShells[m.Cursor].Tools
+		s.WriteString("\n")
+		s.WriteString(UncheckedStyle.Render("    tools: " + strings.Join(tools, ", ")))
+		s.WriteString("\n")
+	}
+
+	s.WriteString("\n")
+	s.WriteString(HelpStyle.Render("c: clone current selection | e: edit | r: reset to current selection | x: drop editors | d: delete | up/down: navigate | Enter/Esc: back to review"))
+	return s.String()
+}
+
+// profileSummary describes the content of a devShell in the profile list.
+func profileSummary(packages, tools int, features []string) string {
+	summary := fmt.Sprintf("%d package(s), %d tool(s)", packages, tools)
+	if len(features) > 0 {
+		summary += ", " + strings.Join(features, ", ")
+	}
+	return summary
+}
+
+// viewShellProfileEditor renders the checklist of a profile's features,
+// packages and tools, scrolled to keep the cursor visible.
+func (m Model) viewShellProfileEditor(profile models.ShellProfile) string {
+	var s strings.Builder
+	s.WriteString(SubtitleStyle.Render(fmt.Sprintf("Shell %s (nix develop .#%s)", profile.Name, profile.Name)))
+	s.WriteString("\n\n")
+
+	rows := m.profileRows(profile)
+	visible := max(m.Height-12, 4)
+	start := min(max(m.ProfileRowCursor-visible/2, 0), max(len(rows)-visible, 0))
+	end := min(start+visible, len(rows))
+	headers := map[string]string{"feature": "Features (this shell only)", "package": "Python packages", "tool": "Tools"}
+	for i := start; i < end; i++ {
+		row := rows[i]
+		if i == start || rows[i-1].Kind != row.Kind {
+			s.WriteString(SubtitleStyle.Render(headers[row.Kind]))
+			s.WriteString("\n")
+		}
+		cursorStr := "  "
+		itemText := row.Attr
+		if i == m.ProfileRowCursor {
+			cursorStr = "> "
+			itemText = SelectedItemStyle.Render(itemText)
+		}
+		checkbox := UncheckedStyle.Render("[ ]")
+		if profileHas(profile, row) {
+			checkbox = CheckboxStyle.Render("[x]")
+		}
+		s.WriteString(fmt.Sprintf("%s%s %s\n", cursorStr, checkbox, itemText))
+	}
+	if end < len(rows) {
+		s.WriteString(UncheckedStyle.Render(fmt.Sprintf("  … %d more", len(rows)-end)))
+		s.WriteString("\n")
+	}
+
+	s.WriteString("\n")
+	s.WriteString(HelpStyle.Render("Space: toggle | up/down: navigate | Enter/Esc: done"))
 	return s.String()
 }
 
@@ -581,8 +1388,15 @@ func (m Model) viewCompletion() string {
 			s.WriteString("Running 'git add flake.nix' first avoids Nix copying the whole folder.\n\n")
 			s.WriteString(HelpStyle.Render("y: git add then nix develop | n: nix develop path:. (copies folder) | esc: cancel"))
 		} else {
+			if m.Config.UseImage {
+				s.WriteString(fmt.Sprintf("Container image: %s\n\n", nix.ImageBuildCommand(m.Config)))
+			}
 			s.WriteString("Would you like to enter the development environment?\n\n")
-			s.WriteString(HelpStyle.Render("y: run 'nix develop path:.' | e: edit flake.nix | n/q: quit"))
+			if m.Config.UseImage {
+				s.WriteString(HelpStyle.Render("y: run 'nix develop path:.' | b: run 'nix build .#dockerImage' | e: edit flake.nix | n/q: quit"))
+			} else {
+				s.WriteString(HelpStyle.Render("y: run 'nix develop path:.' | e: edit flake.nix | n/q: quit"))
+			}
 		}
 	}
 
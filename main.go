@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -9,11 +10,15 @@ import (
 	"strings"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mmxgn/manos-nix-template-builder/internal/nix"
 	"github.com/mmxgn/manos-nix-template-builder/internal/tui"
 )
 
 func main() {
 	outputFlag := flag.String("o", "", "output path for flake.nix")
+	updateIndexFlag := flag.String("update-index", "", "rebuild the package index of a channel branch and exit")
+	importIndexFlag := flag.String("import-index", "", "with -update-index: read saved nix search/nix-env JSON instead of running nix-env")
+	updateChannelsFlag := flag.Bool("update-channels", false, "rediscover the nixpkgs channels and their Python versions and exit")
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage: manos-nix-template-builder [OPTIONS] [PATH]\n\n")
 		fmt.Fprintf(os.Stderr, "Arguments:\n")
@@ -21,10 +26,28 @@ func main() {
 		fmt.Fprintf(os.Stderr, "          If the parent directory does not exist you will be asked to create it.\n\n")
 		fmt.Fprintf(os.Stderr, "Options:\n")
 		fmt.Fprintf(os.Stderr, "  -o PATH    Same as the positional PATH argument\n")
+		fmt.Fprintf(os.Stderr, "  -update-index BRANCH\n")
+		fmt.Fprintf(os.Stderr, "             Rebuild the offline package index of a channel (e.g. nixos-24.11)\n")
+		fmt.Fprintf(os.Stderr, "             with 'nix-env -qaP --json --meta' and exit\n")
+		fmt.Fprintf(os.Stderr, "  -import-index FILE\n")
+		fmt.Fprintf(os.Stderr, "             With -update-index: build the index from saved 'nix search --json'\n")
+		fmt.Fprintf(os.Stderr, "             or 'nix-env -qaP --json --meta' output instead\n")
+		fmt.Fprintf(os.Stderr, "  -update-channels\n")
+		fmt.Fprintf(os.Stderr, "             Rediscover the NixOS release branches and the Python versions each\n")
+		fmt.Fprintf(os.Stderr, "             provides with 'nix eval' and exit (otherwise done weekly in the background)\n")
 		fmt.Fprintf(os.Stderr, "  -h         Show this help message\n")
 	}
 	flag.Parse()
 
+	if *updateIndexFlag != "" {
+		updateIndex(*updateIndexFlag, *importIndexFlag)
+		return
+	}
+	if *updateChannelsFlag {
+		updateChannels()
+		return
+	}
+
 	// Resolve output path: -o flag takes priority, then positional arg, then default
 	outputPath := "./flake.nix"
 	if *outputFlag != "" {
@@ -61,6 +84,39 @@ func main() {
 	}
 }
 
+// updateIndex rebuilds the package index of a branch and reports where it was cached.
+func updateIndex(branch, importPath string) {
+	var index *nix.PackageIndex
+	var err error
+	if importPath != "" {
+		index, err = nix.ImportIndex(branch, importPath)
+	} else {
+		fmt.Printf("Evaluating %s with nix-env (this takes a few minutes)...\n", branch)
+		index, err = nix.RegenerateIndex(branch)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to update the package index: %v\n", err)
+		os.Exit(1)
+	}
+	path, _ := nix.IndexPath(branch)
+	fmt.Printf("Indexed %d packages of %s in %s\n", len(index.Entries), branch, path)
+}
+
+// updateChannels rediscovers the nixpkgs channels and lists what was found.
+func updateChannels() {
+	fmt.Println("Evaluating the NixOS release branches with nix eval (this takes a few minutes)...")
+	discovery, err := nix.DiscoverChannels(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: failed to discover channels: %v\n", err)
+		os.Exit(1)
+	}
+	for _, channel := range discovery.Channels {
+		fmt.Printf("%-16s %s\n", channel.Branch, strings.Join(channel.PythonVersions, " "))
+	}
+	path, _ := nix.ChannelCachePath()
+	fmt.Printf("Cached %d channels in %s\n", len(discovery.Channels), path)
+}
+
 func isYes(s string) bool {
 	s = strings.TrimSpace(strings.ToLower(s))
 	return s == "y" || s == "yes"